@@ -4,4 +4,7 @@ type Client interface {
 	Journals() (Journals, error)
 	Journal(uid string) (*Journal, error)
 	JournalEntries(uid string, last *string) (Entries, error)
+	// CreateEntries appends entries to a journal, last must be the UID of
+	// its last entry or nil if it's empty, otherwise ErrConflict is returned
+	CreateEntries(uid string, last *string, entries Entries) error
 }
@@ -8,16 +8,24 @@ import (
 	"net/http"
 	"net/http/httputil"
 	"strings"
+	"time"
 )
 
 var (
 	// ErrInvalidCredentials denotes invalid credentials when trying to get a API token
 	ErrInvalidCredentials = errors.New("invalid credentials")
+
+	// ErrConflict denotes entries created after a last entry which is not
+	// the last one on the server, the journal must be synced before retrying
+	ErrConflict = errors.New("conflict")
 )
 
 // APIUrl is the default URL
 const APIUrl = "https://api.etesync.com"
 
+// Timeout bounds every request, so an unreachable server doesn't block
+const Timeout = 30 * time.Second
+
 var _ Client = &HTTPClient{}
 
 // HTTPClient is a EteSync API Client
@@ -27,6 +35,7 @@ type HTTPClient struct {
 	password string
 	token    string
 	debug    bool
+	http     *http.Client
 }
 
 // NewClient returns a new HTTPClient given a username and password
@@ -40,6 +49,7 @@ func NewClientWithURL(u, p, url string) (*HTTPClient, error) {
 		apiurl:   url,
 		username: u,
 		password: p,
+		http:     &http.Client{Timeout: Timeout},
 	}
 
 	if err := c.auth(); err != nil {
@@ -81,12 +91,16 @@ func (c *HTTPClient) post(path string, src, dst interface{}) (int, error) {
 		return 0, err
 	}
 
-	resp, err := http.DefaultClient.Do(c.withHeaders(req))
+	resp, err := c.http.Do(c.withHeaders(req))
 	if err != nil {
 		return 0, err
 	}
 	defer resp.Body.Close()
 
+	if dst == nil {
+		return resp.StatusCode, nil
+	}
+
 	if err := json.NewDecoder(resp.Body).Decode(dst); err != nil {
 		return 0, err
 	}
@@ -132,7 +146,7 @@ func (c *HTTPClient) get(path string, dst interface{}) (int, error) {
 		fmt.Println(string(dump))
 	}
 
-	resp, err := http.DefaultClient.Do(c.withHeaders(req))
+	resp, err := c.http.Do(c.withHeaders(req))
 	if err != nil {
 		return 0, err
 	}
@@ -185,3 +199,23 @@ func (c *HTTPClient) JournalEntries(uid string, last *string) (Entries, error) {
 
 	return dst, nil
 }
+
+func (c *HTTPClient) CreateEntries(uid string, last *string, entries Entries) error {
+	target := "api/v1/journals/" + uid + "/entries/"
+	if last != nil {
+		target += "?last=" + *last
+	}
+
+	status, err := c.post(target, entries, nil)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case status == http.StatusConflict:
+		return ErrConflict
+	case status < 200 || status > 299:
+		return fmt.Errorf("creating entries: unexpected status %d", status)
+	}
+	return nil
+}
@@ -1,11 +1,15 @@
 package api
 
 import (
+	"encoding/json"
 	"log"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"testing"
 
 	"github.com/gchaincl/go-etesync/crypto"
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
 
@@ -42,3 +46,51 @@ func TestClient(t *testing.T) {
 		}
 	}
 }
+
+func TestCreateEntries(t *testing.T) {
+	var created Entries
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api-token-auth/":
+			w.Write([]byte(`{"token": "token"}`))
+		case "/api/v1/journals/j1/entries/":
+			assert.Equal(t, "POST", r.Method)
+			assert.Equal(t, "Token token", r.Header.Get("Authorization"))
+
+			var last string
+			if len(created) > 0 {
+				last = created[len(created)-1].UID
+			}
+			if r.URL.Query().Get("last") != last {
+				w.WriteHeader(http.StatusConflict)
+				return
+			}
+
+			var entries Entries
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&entries))
+			created = append(created, entries...)
+			w.WriteHeader(http.StatusCreated)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	c, err := NewClientWithURL("user", "pass", srv.URL)
+	require.NoError(t, err)
+
+	entries := Entries{&Entry{UID: "e1", Content: "c1"}, &Entry{UID: "e2", Content: "c2"}}
+	require.NoError(t, c.CreateEntries("j1", nil, entries))
+	assert.Equal(t, entries, created)
+
+	t.Run("conflict", func(t *testing.T) {
+		last := "e1"
+		err := c.CreateEntries("j1", &last, Entries{&Entry{UID: "e3"}})
+		assert.Equal(t, ErrConflict, err)
+	})
+
+	t.Run("unexpected status", func(t *testing.T) {
+		err := c.CreateEntries("j2", nil, Entries{&Entry{UID: "e3"}})
+		assert.Error(t, err)
+	})
+}
@@ -1,12 +1,28 @@
 package api
 
 import (
+	"crypto/rand"
+	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"io"
 
 	"github.com/gchaincl/go-etesync/crypto"
 )
 
+// CurrentVersion is the journal protocol version used for new journals
+const CurrentVersion = 2
+
+// hmacSize is the length of the HMAC prepended to the journal content
+const hmacSize = sha256.Size
+
+var (
+	// ErrInvalidContent denotes content that is too short to be decoded
+	ErrInvalidContent = errors.New("invalid content")
+)
+
 type Journal struct {
 	Version  int    `json:"version"`
 	UID      string `json:"uid"`
@@ -32,13 +48,51 @@ type JournalContent struct {
 	Color       int         `json:"color"`
 }
 
+// NewJournal returns a new Journal with a random UID and its content encrypted using key
+func NewJournal(t JournalType, displayName string, color int, key []byte) (*Journal, error) {
+	uid, err := newUID()
+	if err != nil {
+		return nil, err
+	}
+
+	j := &Journal{Version: CurrentVersion, UID: uid}
+	jc := &JournalContent{
+		Type:        t,
+		Version:     CurrentVersion,
+		Selected:    true,
+		DisplayName: displayName,
+		Color:       color,
+	}
+
+	if err := j.SetContent(jc, crypto.New([]byte(uid), key)); err != nil {
+		return nil, err
+	}
+
+	return j, nil
+}
+
+// newUID returns the hex encoded sha256 of random bytes
+func newUID() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, buf); err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(buf)
+	return hex.EncodeToString(sum[:]), nil
+}
+
 func (j *Journal) GetContent(cipher *crypto.Cipher) (*JournalContent, error) {
 	content, err := base64.StdEncoding.DecodeString(j.Content)
 	if err != nil {
 		return nil, err
 	}
 
-	data, err := cipher.Decrypt(content[32:])
+	if len(content) < hmacSize {
+		return nil, ErrInvalidContent
+	}
+
+	data, err := cipher.Decrypt(content[hmacSize:])
 	if err != nil {
 		return nil, err
 	}
@@ -51,6 +105,23 @@ func (j *Journal) GetContent(cipher *crypto.Cipher) (*JournalContent, error) {
 	return jc, nil
 }
 
+// SetContent encrypts c and sets it as the journal content, prefixed by its HMAC
+func (j *Journal) SetContent(c *JournalContent, cipher *crypto.Cipher) error {
+	json, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+
+	data, err := cipher.Encrypt(json)
+	if err != nil {
+		return err
+	}
+
+	mac := cipher.HMAC(append(data, j.UID...))
+	j.Content = base64.StdEncoding.EncodeToString(append(mac, data...))
+	return nil
+}
+
 type Journals []*Journal
 
 type Entry struct {
@@ -92,8 +163,29 @@ func (e *Entry) SetContent(c *EntryContent, cipher *crypto.Cipher) error {
 	return nil
 }
 
+// SetUID sets the UID of an entry with encrypted content which follows the
+// entry prevUID, or is the first one if prevUID is empty.
+// The UID chains the entries of a journal, as it's the HMAC of the previous
+// UID and the content.
+func (e *Entry) SetUID(prevUID string, cipher *crypto.Cipher) error {
+	content, err := base64.StdEncoding.DecodeString(e.Content)
+	if err != nil {
+		return err
+	}
+
+	e.UID = hex.EncodeToString(cipher.HMAC(append([]byte(prevUID), content...)))
+	return nil
+}
+
 type Entries []*Entry
 
+// Entry actions
+const (
+	ActionAdd    = "ADD"
+	ActionChange = "CHANGE"
+	ActionDelete = "DELETE"
+)
+
 type EntryContent struct {
 	Action  string
 	Content string
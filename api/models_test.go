@@ -24,3 +24,46 @@ func TestEntryContentEncryption(t *testing.T) {
 
 	assert.Equal(t, ec, newEc)
 }
+
+func TestEntrySetUID(t *testing.T) {
+	cipher := crypto.New([]byte("abcd"), []byte("encryption key"))
+
+	e := &Entry{}
+	require.NoError(t, e.SetContent(&EntryContent{Action: "ADD", Content: "string"}, cipher))
+
+	require.NoError(t, e.SetUID("", cipher))
+	first := e.UID
+	assert.Len(t, first, 64)
+
+	require.NoError(t, e.SetUID("", cipher))
+	assert.Equal(t, first, e.UID, "should be deterministic")
+
+	require.NoError(t, e.SetUID(first, cipher))
+	assert.NotEqual(t, first, e.UID, "should depend on the previous uid")
+}
+
+func TestJournalContentEncryption(t *testing.T) {
+	key := []byte("encryption key")
+
+	jn, err := NewJournal(JournalCalendar, "My Calendar", 0xff0000, key)
+	require.NoError(t, err)
+	assert.Len(t, jn.UID, 64)
+	assert.Equal(t, CurrentVersion, jn.Version)
+
+	jc, err := jn.GetContent(crypto.New([]byte(jn.UID), key))
+	require.NoError(t, err)
+
+	assert.Equal(t, &JournalContent{
+		Type:        JournalCalendar,
+		Version:     CurrentVersion,
+		Selected:    true,
+		DisplayName: "My Calendar",
+		Color:       0xff0000,
+	}, jc)
+
+	t.Run("unique uids", func(t *testing.T) {
+		other, err := NewJournal(JournalCalendar, "My Calendar", 0, key)
+		require.NoError(t, err)
+		assert.NotEqual(t, jn.UID, other.UID)
+	})
+}
@@ -0,0 +1,147 @@
+package cache
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/gchaincl/go-etesync/api"
+	"github.com/gchaincl/go-etesync/crypto"
+)
+
+// Notification describes the changes a sync stored on a journal
+type Notification struct {
+	JournalUID string
+	// Entries are the entries stored by the sync, pulled or pushed
+	Entries api.Entries
+	// ItemUIDs are the items added, changed or deleted by the entries
+	ItemUIDs []string
+}
+
+// subscriber receives notifications on ch until done is closed
+type subscriber struct {
+	mu   sync.RWMutex
+	ch   chan Notification
+	done chan struct{}
+}
+
+// Subscribe returns a channel receiving a Notification for every journal
+// changed by a sync, until cancel is called which closes it. A sync waits for
+// the notification to be received or buffered, so the channel must be
+// drained.
+func (c *Cache) Subscribe(buffer int) (<-chan Notification, func()) {
+	sub := &subscriber{
+		ch:   make(chan Notification, buffer),
+		done: make(chan struct{}),
+	}
+
+	c.mu.Lock()
+	c.subscribers = append(c.subscribers, sub)
+	c.mu.Unlock()
+
+	var once sync.Once
+	cancel := func() {
+		once.Do(func() {
+			c.mu.Lock()
+			for i, found := range c.subscribers {
+				if found == sub {
+					c.subscribers = append(c.subscribers[:i:i], c.subscribers[i+1:]...)
+					break
+				}
+			}
+			c.mu.Unlock()
+
+			// unblock a pending send before closing the channel
+			close(sub.done)
+			sub.mu.Lock()
+			close(sub.ch)
+			sub.mu.Unlock()
+		})
+	}
+	return sub.ch, cancel
+}
+
+// publish sends n to the subscribers
+func (c *Cache) publish(n Notification) {
+	c.mu.Lock()
+	subscribers := c.subscribers
+	c.mu.Unlock()
+
+	for _, sub := range subscribers {
+		sub.mu.RLock()
+		select {
+		case <-sub.done:
+		default:
+			select {
+			case sub.ch <- n:
+			case <-sub.done:
+			}
+		}
+		sub.mu.RUnlock()
+	}
+}
+
+// hasSubscribers reports whether notifications have to be built
+func (c *Cache) hasSubscribers() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.subscribers) > 0
+}
+
+// notifyChanges publishes the entries stored on a journal after the entry
+// prev, or from the first one if prev is empty
+func (c *Cache) notifyChanges(uid, prev string) error {
+	entries, err := c.store.EntriesAfter(uid, prev, 0)
+	if err != nil || len(entries) == 0 {
+		return err
+	}
+
+	n := Notification{JournalUID: uid, Entries: entries}
+	cipher := crypto.New([]byte(uid), c.key)
+	seen := make(map[string]bool)
+	for _, e := range entries {
+		if itemUID := entryItemUID(cipher, e); itemUID != "" && !seen[itemUID] {
+			seen[itemUID] = true
+			n.ItemUIDs = append(n.ItemUIDs, itemUID)
+		}
+	}
+
+	c.publish(n)
+	return nil
+}
+
+// StartAutoSync syncs in the background every interval plus a random delay
+// up to jitter, so several clients don't hit the server at once. Failures
+// are reported to the observers. The returned function stops it, waiting for
+// a running sync to finish.
+func (c *Cache) StartAutoSync(interval, jitter time.Duration) (stop func()) {
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+
+	go func() {
+		defer close(stopped)
+		for {
+			wait := interval
+			if jitter > 0 {
+				wait += time.Duration(rand.Int63n(int64(jitter)))
+			}
+
+			select {
+			case <-done:
+				return
+			case <-time.After(wait):
+			}
+
+			// errors reach the observers as SyncFailed events
+			_, _ = c.Sync()
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			close(done)
+			<-stopped
+		})
+	}
+}
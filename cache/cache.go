@@ -1,62 +1,762 @@
 package cache
 
 import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
 	"github.com/gchaincl/go-etesync/api"
+	"github.com/gchaincl/go-etesync/crypto"
+	"github.com/gchaincl/go-etesync/pim"
 	"github.com/gchaincl/go-etesync/store"
 )
 
+var (
+	// ErrOffline is returned when syncing a Cache created without a client
+	ErrOffline = errors.New("cache is offline")
+)
+
 type Cache struct {
-	store store.Store
-	api   api.Client
+	store    store.Store
+	api      api.Client
+	key      []byte
+	index    store.Index
+	removal  RemovalPolicy
+	resolver Resolver
+	failFast bool
+	workers  int
+	filter   SyncFilter
+	contents *lru
+
+	// syncing serializes the syncs, so a background one doesn't overlap with
+	// a manual one, and the compactions with them
+	syncing     sync.Mutex
+	mu          sync.Mutex
+	observers   []*observer
+	subscribers []*subscriber
+}
+
+// RemovalPolicy is what Sync does with the stored journals which are no
+// longer available on the server, because they were deleted or unshared
+type RemovalPolicy int
+
+const (
+	// ArchiveRemoved keeps the data of removed journals, which are then
+	// listed by ArchivedJournals instead of Journals
+	ArchiveRemoved RemovalPolicy = iota
+	// PurgeRemoved deletes the data of removed journals
+	PurgeRemoved
+)
+
+// SyncReport describes what a Sync did besides storing the new entries
+type SyncReport struct {
+	// Removed are the journals found removed from the server
+	Removed api.Journals
+	// Skipped are the journals not selected by the sync filter
+	Skipped api.Journals
+	// Discarded are the conflicts resolved keeping the server item, whose
+	// local changes were dropped (eg. by the default ServerWins)
+	Discarded []*Conflict
+}
+
+// JournalError is the error syncing a journal
+type JournalError struct {
+	JournalUID string
+	Err        error
+}
+
+func (e *JournalError) Error() string {
+	return fmt.Sprintf("journal %s: %v", e.JournalUID, e.Err)
+}
+
+func (e *JournalError) Unwrap() error {
+	return e.Err
+}
+
+// SyncError lists the journals which failed to sync
+type SyncError []*JournalError
+
+func (e SyncError) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return fmt.Sprintf("%d journals failed to sync: %s", len(e), strings.Join(msgs, "; "))
+}
+
+// Is reports whether any journal failed with target, so errors.Is works on
+// the error of Sync as it did before the errors were wrapped
+func (e SyncError) Is(target error) bool {
+	for _, err := range e {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// Option configures a Cache
+type Option func(*Cache)
+
+// WithIndex keeps idx up to date with the decrypted items on every sync
+func WithIndex(idx store.Index) Option {
+	return func(c *Cache) { c.index = idx }
+}
+
+// WithRemovalPolicy sets what Sync does with removed journals, they are
+// archived by default
+func WithRemovalPolicy(p RemovalPolicy) Option {
+	return func(c *Cache) { c.removal = p }
+}
+
+// WithFailFast makes Sync stop on the first journal which fails to sync,
+// by default the remaining journals are synced anyway
+func WithFailFast() Option {
+	return func(c *Cache) { c.failFast = true }
+}
+
+// WithConcurrency makes Sync sync up to n journals at once, they are synced
+// one at a time by default
+func WithConcurrency(n int) Option {
+	return func(c *Cache) { c.workers = n }
 }
 
-func New(s store.Store, c api.Client) *Cache {
-	return &Cache{store: s, api: c}
+// New returns a new Cache, key is used to decrypt the entries and keep the
+// current items up to date.
+// The client may be nil to work offline on the stored data, local changes are
+// then queued until a Cache with a client syncs them.
+// Several accounts can share a store creating a Cache per account on
+// s.ForAccount(store.AccountID(url, username)).
+func New(s store.Store, c api.Client, key []byte, opts ...Option) *Cache {
+	cache := &Cache{store: s, api: c, key: key, contents: newLRU(DefaultContentCacheSize)}
+	for _, opt := range opts {
+		opt(cache)
+	}
+	return cache
 }
 
-// Sync syncs all the available journals
-func (c *Cache) Sync() error {
+// Sync syncs all the available journals, each journal is written atomically.
+// A journal which fails to sync doesn't stop the others, unless the Cache was
+// created WithFailFast, and their errors are returned as a SyncError ordered
+// as the journals, even when they're synced concurrently.
+// The report is returned even if Sync fails.
+func (c *Cache) Sync() (*SyncReport, error) {
+	c.syncing.Lock()
+	defer c.syncing.Unlock()
+
+	report := &SyncReport{}
+	if c.api == nil {
+		return report, ErrOffline
+	}
+
 	js, err := c.api.Journals()
+	if err == nil {
+		report.Removed, err = c.removeJournals(js)
+	}
+	if err != nil {
+		c.notify(ProgressEvent{Kind: SyncFailed, Err: err})
+		return report, err
+	}
+
+	var failed SyncError
+	js, report.Skipped, failed = c.filterJournals(js)
+	if c.failFast && len(failed) > 0 {
+		return report, failed
+	}
+
+	discarded, errs := c.syncJournals(js)
+	report.Discarded = discarded
+	if failed = append(failed, errs...); len(failed) > 0 {
+		return report, failed
+	}
+	return report, nil
+}
+
+// syncJournals syncs the journals on a pool of workers, returning the
+// conflicts discarded by the resolver
+func (c *Cache) syncJournals(js api.Journals) ([]*Conflict, SyncError) {
+	workers := c.workers
+	if workers < 1 {
+		workers = 1
+	}
+	if workers > len(js) {
+		workers = len(js)
+	}
+
+	// errors are kept by position so they're ordered as the journals
+	errs := make([]error, len(js))
+	discarded := make([][]*Conflict, len(js))
+	var abort int32
+
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				// with fail-fast the remaining journals are skipped
+				if c.failFast && atomic.LoadInt32(&abort) == 1 {
+					continue
+				}
+
+				discarded[i], errs[i] = c.syncJournal(js[i].UID, js[i], i+1, len(js))
+				if errs[i] != nil {
+					atomic.StoreInt32(&abort, 1)
+				}
+			}
+		}()
+	}
+
+	for i := range js {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	var conflicts []*Conflict
+	var failed SyncError
+	for i, err := range errs {
+		conflicts = append(conflicts, discarded[i]...)
+		if err != nil {
+			failed = append(failed, &JournalError{JournalUID: js[i].UID, Err: err})
+		}
+	}
+	return conflicts, failed
+}
+
+// removeJournals applies the removal policy to the stored journals missing
+// from the available ones, returning them
+func (c *Cache) removeJournals(available api.Journals) (api.Journals, error) {
+	stored, err := c.store.GetJournals()
+	if err != nil {
+		return nil, err
+	}
+
+	uids := make(map[string]bool, len(available))
+	for _, j := range available {
+		uids[j.UID] = true
+	}
+
+	var removed api.Journals
+	for _, j := range stored {
+		if uids[j.UID] {
+			continue
+		}
+
+		state, err := c.store.SyncState(j.UID)
+		if err == store.ErrRecordNotFound {
+			state = &store.SyncState{JournalUID: j.UID}
+		} else if err != nil {
+			return nil, err
+		}
+
+		if !state.Removed.IsZero() {
+			// already archived
+			continue
+		}
+
+		if c.removal == PurgeRemoved {
+			err = c.purgeJournal(j.UID)
+		} else {
+			state.Removed = time.Now()
+			err = c.store.PutSyncState(state)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		removed = append(removed, j)
+	}
+	return removed, nil
+}
+
+// purgeJournal deletes the data of a journal, including its indexed items
+func (c *Cache) purgeJournal(uid string) error {
+	items, err := c.store.Items(uid)
+	if err != nil {
+		return err
+	}
+
+	if err := c.store.PurgeJournal(uid); err != nil {
+		return err
+	}
+	c.contents.removeJournal(uid)
+
+	changes := make([]*change, len(items))
+	for i, item := range items {
+		changes[i] = &change{uid: item.UID}
+	}
+	return c.indexChanges(uid, changes)
+}
+
+// saveJournal creates or updates a journal in the store
+func saveJournal(s store.Store, j *api.Journal) error {
+	_, err := s.GetJournal(j.UID)
+	if err == store.ErrRecordNotFound {
+		return s.CreateJournal(j)
+	}
+	if err != nil {
+		return err
+	}
+
+	return s.UpdateJournal(j)
+}
+
+// SyncJournal write to the last entries (using the ?last arg) to the store
+func (c *Cache) SyncJournal(uid string) error {
+	c.syncing.Lock()
+	defer c.syncing.Unlock()
+
+	if c.api == nil {
+		return ErrOffline
+	}
+	_, err := c.syncJournal(uid, nil, 1, 1)
+	return err
+}
+
+// syncJournal pulls a journal, pushes its pending changes and records the
+// outcome in its sync state. pos and total are reported to the observers.
+// It returns the conflicts discarded by the resolver.
+func (c *Cache) syncJournal(uid string, j *api.Journal, pos, total int) ([]*Conflict, error) {
+	ev := ProgressEvent{JournalUID: uid, Journal: pos, Journals: total}
+	notify := func(kind ProgressKind, entries int, err error) {
+		ev.Kind, ev.Entries, ev.Err = kind, entries, err
+		c.notify(ev)
+	}
+
+	notify(JournalStarted, 0, nil)
+	start := time.Now()
+
+	// the entries stored after prev are published to the subscribers
+	var prev string
+	var err error
+	subscribed := c.hasSubscribers()
+	if subscribed {
+		prev, err = lastEntryUID(c.store, uid)
+	}
+
+	p := &pulled{}
+	if err == nil {
+		p, err = c.pull(uid, j)
+	}
+	if len(p.entries) > 0 {
+		notify(EntriesFetched, len(p.entries), nil)
+	}
+	// the journal content may have changed, entries never change
+	c.contents.remove(lruKey{journalUID: uid})
+	discarded := p.discarded
+	if err == nil {
+		var pushed int
+		var retried []*Conflict
+		pushed, retried, err = c.push(uid)
+		discarded = append(discarded, retried...)
+		if pushed > 0 {
+			notify(EntriesPushed, pushed, nil)
+		}
+	}
+	if serr := c.saveSyncState(uid, start, len(p.entries), p.invalid, err); err == nil {
+		err = serr
+	}
+	if subscribed {
+		if nerr := c.notifyChanges(uid, prev); err == nil {
+			err = nerr
+		}
+	}
+
+	if err != nil {
+		notify(SyncFailed, 0, err)
+	}
+	notify(JournalFinished, 0, err)
+	return discarded, err
+}
+
+// pulled is the outcome of pulling a journal
+type pulled struct {
+	entries api.Entries
+	// invalid is how many entries couldn't be decrypted or parsed
+	invalid int
+	// discarded are the conflicts resolved dropping the local changes
+	discarded []*Conflict
+}
+
+// pull stores the new entries of a journal, and j unless it's nil, within a
+// transaction, resolving conflicts and rebasing the pending changes on top
+// of them.
+// It returns the outcome even if indexing the entries fails.
+func (c *Cache) pull(uid string, j *api.Journal) (*pulled, error) {
+	entries, err := c.fetch(uid)
+	if err != nil {
+		return &pulled{}, err
+	}
+
+	p := &pulled{entries: entries}
+	var changes []*change
+	err = c.store.WithTx(func(s store.Store) error {
+		if j != nil {
+			if err := saveJournal(s, j); err != nil {
+				return err
+			}
+		}
+		if err := reconcile(s, uid, entries); err != nil {
+			return err
+		}
+
+		conflicts, err := c.findConflicts(s, uid, entries)
+		if err != nil {
+			return err
+		}
+
+		if changes, p.invalid, err = c.write(s, uid, entries); err != nil {
+			return err
+		}
+
+		if p.discarded, err = c.resolve(s, uid, conflicts); err != nil {
+			return err
+		}
+
+		rebased, err := c.rebase(s, uid)
+		changes = append(changes, rebased...)
+		return err
+	})
+	if err != nil {
+		return &pulled{}, err
+	}
+
+	return p, c.indexChanges(uid, changes)
+}
+
+// Purge deletes the data of every journal, use it on a Cache created with
+// store.ForAccount to remove an account
+func (c *Cache) Purge() error {
+	js, err := c.store.GetJournals()
+	if err != nil {
+		return err
+	}
+
+	states, err := c.store.SyncStates()
 	if err != nil {
 		return err
 	}
 
+	pending, err := c.store.PendingChanges("")
+	if err != nil {
+		return err
+	}
+
+	// journals which never synced successfully have no stored journal
+	var uids []string
+	seen := make(map[string]bool)
+	add := func(uid string) {
+		if !seen[uid] {
+			seen[uid] = true
+			uids = append(uids, uid)
+		}
+	}
 	for _, j := range js {
-		if err := c.SyncJournal(j.UID); err != nil {
+		add(j.UID)
+	}
+	for _, st := range states {
+		add(st.JournalUID)
+	}
+	for _, ch := range pending {
+		add(ch.JournalUID)
+	}
+
+	for _, uid := range uids {
+		if err := c.purgeJournal(uid); err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
-// SyncJournal write to the last entries (using the ?last arg) to the store
-func (c *Cache) SyncJournal(uid string) error {
+// Compact deletes the history of every journal not kept by the retention,
+// returning how many entries were deleted. The current items are kept. It
+// waits for a running sync to finish.
+func (c *Cache) Compact(r store.Retention) (int, error) {
+	c.syncing.Lock()
+	defer c.syncing.Unlock()
+
+	js, err := c.store.GetJournals()
+	if err != nil {
+		return 0, err
+	}
+
+	var deleted int
+	for _, j := range js {
+		var n int
+		err := c.store.WithTx(func(s store.Store) (err error) {
+			if n, err = s.CompactEntries(j.UID, r); err != nil || n == 0 {
+				return err
+			}
+			return compacted(s, j.UID)
+		})
+		if err != nil {
+			return deleted, err
+		}
+		deleted += n
+	}
+	return deleted, nil
+}
+
+// compacted records the first entry kept by a compaction of a journal, so
+// Verify tells the deleted entries apart from the missing ones
+func compacted(s store.Store, uid string) error {
+	first, err := s.EntriesAfter(uid, "", 1)
+	if err != nil || len(first) == 0 {
+		return err
+	}
+
+	state, err := s.SyncState(uid)
+	if err == store.ErrRecordNotFound {
+		state = &store.SyncState{JournalUID: uid}
+	} else if err != nil {
+		return err
+	}
+	state.CompactedBefore = first[0].UID
+	return s.PutSyncState(state)
+}
+
+// saveSyncState records the outcome of a sync started at start, which stored
+// entries of which invalid couldn't be decrypted or parsed
+func (c *Cache) saveSyncState(uid string, start time.Time, entries, invalid int, err error) error {
+	state, serr := c.store.SyncState(uid)
+	if serr == store.ErrRecordNotFound {
+		state = &store.SyncState{JournalUID: uid}
+	} else if serr != nil {
+		return serr
+	}
+
+	// the journal is available again if it was removed
+	state.Removed = time.Time{}
+	state.LastAttempt = start
+	state.Duration = time.Since(start)
+	state.Entries = entries
+	state.Invalid = invalid
+	last, lerr := c.store.LastEntry(uid)
+	if lerr == nil {
+		state.LastEntryUID = last.UID
+	} else if lerr != store.ErrRecordNotFound {
+		return lerr
+	}
+
+	state.Error = ""
+	if err != nil {
+		state.Error = err.Error()
+	} else {
+		state.LastSuccess = start
+	}
+
+	return c.store.PutSyncState(state)
+}
+
+// lastEntryUID returns the uid of the last stored entry of a journal, or an
+// empty string if there are none
+func lastEntryUID(s store.Store, uid string) (string, error) {
+	e, err := s.LastEntry(uid)
+	if err == store.ErrRecordNotFound {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return e.UID, nil
+}
+
+// fetch retrieves the entries newer than the last stored one
+func (c *Cache) fetch(uid string) (api.Entries, error) {
 	e, err := c.store.LastEntry(uid)
 	if err != nil && err != store.ErrRecordNotFound {
-		return err
+		return nil, err
 	}
 
 	var last *string = nil
 	if err != store.ErrRecordNotFound {
 		last = &e.UID
 	}
-	entries, err := c.api.JournalEntries(uid, last)
+	return c.api.JournalEntries(uid, last)
+}
+
+// change is the change an entry applied to an item, item is nil when it was
+// deleted
+type change struct {
+	uid  string
+	item pim.Item
+}
+
+// write stores the fetched entries and applies them to the current items.
+// Entries which can't be decrypted or parsed are stored without changing the
+// items, so they don't stop the journal from syncing, and counted as invalid.
+func (c *Cache) write(s store.Store, uid string, entries api.Entries) ([]*change, int, error) {
+	var changes []*change
+	var invalid int
+
+	cipher := crypto.New([]byte(uid), c.key)
+	for _, e := range entries {
+		if err := s.CreateEntry(uid, e); err != nil {
+			return nil, 0, err
+		}
+
+		ch, err := apply(s, uid, cipher, e)
+		if _, ok := err.(*invalidEntryError); ok {
+			invalid++
+			continue
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+
+		if ch != nil {
+			changes = append(changes, ch)
+		}
+	}
+	return changes, invalid, nil
+}
+
+// invalidEntryError is returned by apply for an entry which can't be
+// decrypted or parsed
+type invalidEntryError struct {
+	uid string
+	err error
+}
+
+func (e *invalidEntryError) Error() string {
+	return fmt.Sprintf("invalid entry %s: %v", e.uid, e.err)
+}
+
+// apply updates the current item changed by an entry
+func apply(s store.Store, uid string, cipher *crypto.Cipher, e *api.Entry) (*change, error) {
+	content, err := e.GetContent(cipher)
 	if err != nil {
-		return err
+		return nil, &invalidEntryError{uid: e.UID, err: err}
 	}
 
-	for _, e := range entries {
-		if err := c.store.CreateEntry(uid, e); err != nil {
-			return err
+	item, err := pim.FromEntry(content)
+	if err == pim.ErrUnknownComponent {
+		// not an item we know how to track
+		return nil, nil
+	}
+	if err != nil {
+		return nil, &invalidEntryError{uid: e.UID, err: err}
+	}
+
+	itemUID := item.ItemUID()
+	if content.Action == api.ActionDelete {
+		err := s.DeleteItem(uid, itemUID)
+		if err != nil && err != store.ErrRecordNotFound {
+			return nil, err
 		}
+		return &change{uid: itemUID}, nil
 	}
-	return nil
+
+	if err := s.PutItem(uid, &store.Item{UID: itemUID, Entry: e}); err != nil {
+		return nil, err
+	}
+	return &change{uid: itemUID, item: item}, nil
 }
 
+// Journals returns the journals stored by the last Sync
 func (c *Cache) Journals() (api.Journals, error) {
-	return c.api.Journals()
+	return c.journals(false)
+}
+
+// ArchivedJournals returns the stored journals which were removed from the
+// server
+func (c *Cache) ArchivedJournals() (api.Journals, error) {
+	return c.journals(true)
+}
+
+func (c *Cache) journals(archived bool) (api.Journals, error) {
+	js, err := c.store.GetJournals()
+	if err != nil {
+		return nil, err
+	}
+
+	states, err := c.store.SyncStates()
+	if err != nil {
+		return nil, err
+	}
+
+	removed := make(map[string]bool, len(states))
+	for _, st := range states {
+		removed[st.JournalUID] = !st.Removed.IsZero()
+	}
+
+	var found api.Journals
+	for _, j := range js {
+		if removed[j.UID] == archived {
+			found = append(found, j)
+		}
+	}
+	return found, nil
+}
+
+// LastSync returns when all the journals were last synced successfully, which
+// is the oldest success among them. It's zero if any journal never synced.
+// The journals skipped by the sync filter are left out.
+func (c *Cache) LastSync() (time.Time, error) {
+	js, err := c.Journals()
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	// the journals which can't be decrypted are kept, they fail to sync
+	selected, _, failed := c.filterJournals(js)
+	for _, jerr := range failed {
+		if j, err := c.Journal(jerr.JournalUID); err == nil {
+			selected = append(selected, j)
+		}
+	}
+	if js = selected; len(js) == 0 {
+		return time.Time{}, nil
+	}
+
+	var last time.Time
+	for i, j := range js {
+		st, err := c.store.SyncState(j.UID)
+		if err == store.ErrRecordNotFound {
+			return time.Time{}, nil
+		}
+		if err != nil {
+			return time.Time{}, err
+		}
+
+		if i == 0 || st.LastSuccess.Before(last) {
+			last = st.LastSuccess
+		}
+	}
+	return last, nil
+}
+
+// Journal returns a stored journal given its uid
+func (c *Cache) Journal(uid string) (*api.Journal, error) {
+	return c.store.GetJournal(uid)
 }
 
 func (c *Cache) JournalEntries(uid string) (api.Entries, error) {
 	return c.store.GetEntries(uid)
 }
+
+// SyncState returns the outcome of the last sync of a journal
+func (c *Cache) SyncState(uid string) (*store.SyncState, error) {
+	return c.store.SyncState(uid)
+}
+
+// SyncStates returns the outcome of the last sync of every journal
+func (c *Cache) SyncStates() (store.SyncStates, error) {
+	return c.store.SyncStates()
+}
+
+// Items returns the current items of a journal
+func (c *Cache) Items(uid string) (store.Items, error) {
+	return c.store.Items(uid)
+}
+
+// Item returns the current state of an item given its iCalendar/vCard UID
+func (c *Cache) Item(journalUID, itemUID string) (*store.Item, error) {
+	return c.store.Item(journalUID, itemUID)
+}
@@ -0,0 +1,1483 @@
+package cache
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gchaincl/go-etesync/api"
+	"github.com/gchaincl/go-etesync/crypto"
+	"github.com/gchaincl/go-etesync/pim"
+	"github.com/gchaincl/go-etesync/store"
+	"github.com/gchaincl/go-etesync/store/memory"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeClient is an in memory api.Client
+type fakeClient struct {
+	mu       sync.Mutex
+	journals api.Journals
+	entries  map[string]api.Entries
+	// onCreate is called before CreateEntries checks for conflicts
+	onCreate func(uid string)
+	// onFetch is called by JournalEntries before looking up the entries
+	onFetch func(uid string)
+	// fetchErrs are returned by JournalEntries for the given journals
+	fetchErrs map[string]error
+}
+
+var _ api.Client = &fakeClient{}
+
+func newFakeClient() *fakeClient {
+	return &fakeClient{entries: make(map[string]api.Entries)}
+}
+
+func (f *fakeClient) Journals() (api.Journals, error) {
+	return f.journals, nil
+}
+
+func (f *fakeClient) Journal(uid string) (*api.Journal, error) {
+	for _, j := range f.journals {
+		if j.UID == uid {
+			return j, nil
+		}
+	}
+	return nil, nil
+}
+
+func (f *fakeClient) JournalEntries(uid string, last *string) (api.Entries, error) {
+	if f.onFetch != nil {
+		f.onFetch(uid)
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.fetchErrs[uid]; err != nil {
+		return nil, err
+	}
+
+	entries := f.entries[uid]
+	if last == nil {
+		return entries, nil
+	}
+
+	for i, e := range entries {
+		if e.UID == *last {
+			return entries[i+1:], nil
+		}
+	}
+	return nil, nil
+}
+
+func (f *fakeClient) CreateEntries(uid string, last *string, entries api.Entries) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.onCreate != nil {
+		f.onCreate(uid)
+	}
+
+	current := f.entries[uid]
+	if n := len(current); (last == nil) != (n == 0) || (n > 0 && current[n-1].UID != *last) {
+		return api.ErrConflict
+	}
+
+	f.entries[uid] = append(current, entries...)
+	return nil
+}
+
+var (
+	testKey  = []byte("test key")
+	errFetch = errors.New("fetch failure")
+)
+
+// failingStore fails to create the entries selected by fail, also within
+// transactions
+type failingStore struct {
+	store.Store
+	fail func(e *api.Entry) bool
+}
+
+func (s *failingStore) CreateEntry(j string, e *api.Entry) error {
+	if s.fail != nil && s.fail(e) {
+		return errors.New("store failure")
+	}
+	return s.Store.CreateEntry(j, e)
+}
+
+func (s *failingStore) WithTx(fn func(store.Store) error) error {
+	return s.Store.WithTx(func(tx store.Store) error {
+		return fn(&failingStore{Store: tx, fail: s.fail})
+	})
+}
+
+// failEntry selects the entry with the given uid
+func failEntry(uid string) func(*api.Entry) bool {
+	return func(e *api.Entry) bool { return e.UID == uid }
+}
+
+func newTestCache(t *testing.T, client api.Client) *Cache {
+	return New(memory.NewStore(), client, testKey)
+}
+
+// newEntry returns an entry of journal j with a vCard of the given item uid
+func newEntry(t *testing.T, j, uid, action, item string) *api.Entry {
+	vcard := fmt.Sprintf("BEGIN:VCARD\r\nVERSION:3.0\r\nUID:%s\r\nFN:%s\r\nEND:VCARD\r\n", item, uid)
+
+	e := &api.Entry{UID: uid}
+	cipher := crypto.New([]byte(j), testKey)
+	require.NoError(t, e.SetContent(&api.EntryContent{Action: action, Content: vcard}, cipher))
+	return e
+}
+
+// newItemEntry returns an entry of journal j holding item
+func newItemEntry(t *testing.T, j, uid, action string, item pim.Item) *api.Entry {
+	e := &api.Entry{UID: uid}
+	cipher := crypto.New([]byte(j), testKey)
+	require.NoError(t, e.SetContent(pim.ToEntry(action, item), cipher))
+	return e
+}
+
+// assertItem asserts that content holds expected, comparing the properties
+// of the items regardless of how they're encoded
+func assertItem(t *testing.T, expected pim.Item, content string) {
+	item, err := pim.Parse(content)
+	require.NoError(t, err)
+	require.IsType(t, expected, item)
+
+	e, a := reflect.ValueOf(expected).Elem(), reflect.ValueOf(item).Elem()
+	for i := 0; i < e.NumField(); i++ {
+		if f := e.Type().Field(i); f.PkgPath == "" {
+			assert.Equal(t, e.Field(i).Interface(), a.Field(i).Interface(), f.Name)
+		}
+	}
+}
+
+func TestSync(t *testing.T) {
+	client := newFakeClient()
+	client.journals = api.Journals{
+		&api.Journal{UID: "j1", Content: "v1"},
+		&api.Journal{UID: "j2", Content: "v1"},
+	}
+	client.entries["j1"] = api.Entries{
+		newEntry(t, "j1", "e1", api.ActionAdd, "item1"),
+		newEntry(t, "j1", "e2", api.ActionAdd, "item2"),
+	}
+
+	c := newTestCache(t, client)
+
+	_, err := c.Sync()
+	require.NoError(t, err)
+
+	js, err := c.Journals()
+	require.NoError(t, err)
+	assert.Equal(t, client.journals, js)
+
+	es, err := c.JournalEntries("j1")
+	require.NoError(t, err)
+	assert.Equal(t, client.entries["j1"], es)
+
+	t.Run("updates", func(t *testing.T) {
+		client.journals[0].Content = "v2"
+		client.entries["j1"] = append(client.entries["j1"], newEntry(t, "j1", "e3", api.ActionAdd, "item3"))
+		_, err := c.Sync()
+		require.NoError(t, err)
+
+		j, err := c.Journal("j1")
+		require.NoError(t, err)
+		assert.Equal(t, "v2", j.Content)
+
+		es, err := c.JournalEntries("j1")
+		require.NoError(t, err)
+		assert.Equal(t, client.entries["j1"], es)
+	})
+}
+
+func TestItems(t *testing.T) {
+	client := newFakeClient()
+	client.journals = api.Journals{&api.Journal{UID: "j1"}}
+	client.entries["j1"] = api.Entries{
+		newEntry(t, "j1", "e1", api.ActionAdd, "item1"),
+		newEntry(t, "j1", "e2", api.ActionAdd, "item2"),
+		newEntry(t, "j1", "e3", api.ActionAdd, "item3"),
+		newEntry(t, "j1", "e4", api.ActionChange, "item1"),
+		newEntry(t, "j1", "e5", api.ActionDelete, "item2"),
+	}
+
+	c := newTestCache(t, client)
+
+	_, err := c.Sync()
+	require.NoError(t, err)
+
+	items, err := c.Items("j1")
+	require.NoError(t, err)
+	require.Len(t, items, 2)
+	assert.Equal(t, "item1", items[0].UID)
+	assert.Equal(t, "e4", items[0].Entry.UID)
+	assert.Equal(t, "item3", items[1].UID)
+	assert.Equal(t, "e3", items[1].Entry.UID)
+
+	item, err := c.Item("j1", "item1")
+	require.NoError(t, err)
+	assert.Equal(t, client.entries["j1"][3], item.Entry)
+}
+
+func TestSyncIsAtomic(t *testing.T) {
+	client := newFakeClient()
+	client.journals = api.Journals{&api.Journal{UID: "j1"}}
+	client.entries["j1"] = api.Entries{
+		newEntry(t, "j1", "e1", api.ActionAdd, "item1"),
+		newEntry(t, "j1", "e2", api.ActionAdd, "item2"),
+	}
+
+	c := New(&failingStore{Store: memory.NewStore(), fail: failEntry("e2")}, client, testKey)
+	_, err := c.Sync()
+	require.Error(t, err)
+
+	js, err := c.Journals()
+	require.NoError(t, err)
+	assert.Len(t, js, 0)
+
+	es, err := c.JournalEntries("j1")
+	require.NoError(t, err)
+	assert.Len(t, es, 0)
+}
+
+func TestSyncInvalidEntries(t *testing.T) {
+	client := newFakeClient()
+	client.journals = api.Journals{&api.Journal{UID: "j1"}}
+
+	cipher := crypto.New([]byte("j1"), testKey)
+	unparsable := &api.Entry{UID: "e2"}
+	require.NoError(t, unparsable.SetContent(&api.EntryContent{Action: api.ActionAdd, Content: "not a vcard"}, cipher))
+	client.entries["j1"] = api.Entries{
+		// can't be decrypted
+		&api.Entry{UID: "e1", Content: "invalid"},
+		unparsable,
+		newEntry(t, "j1", "e3", api.ActionAdd, "item3"),
+		newEntry(t, "j1", "e4", api.ActionAdd, "item4"),
+	}
+
+	c := newTestCache(t, client)
+
+	_, err := c.Sync()
+	require.NoError(t, err)
+
+	es, err := c.JournalEntries("j1")
+	require.NoError(t, err)
+	assert.Equal(t, client.entries["j1"], es)
+
+	items, err := c.Items("j1")
+	require.NoError(t, err)
+	require.Len(t, items, 2)
+	assert.Equal(t, "item3", items[0].UID)
+	assert.Equal(t, "item4", items[1].UID)
+
+	st, err := c.SyncState("j1")
+	require.NoError(t, err)
+	assert.Equal(t, "", st.Error)
+	assert.Equal(t, 4, st.Entries)
+	assert.Equal(t, 2, st.Invalid)
+
+	t.Run("next syncs", func(t *testing.T) {
+		client.entries["j1"] = append(client.entries["j1"], newEntry(t, "j1", "e5", api.ActionAdd, "item5"))
+		_, err := c.Sync()
+		require.NoError(t, err)
+
+		st, err := c.SyncState("j1")
+		require.NoError(t, err)
+		assert.Equal(t, "e5", st.LastEntryUID)
+		assert.Equal(t, 0, st.Invalid)
+	})
+}
+
+func TestSyncState(t *testing.T) {
+	client := newFakeClient()
+	client.journals = api.Journals{&api.Journal{UID: "j1"}}
+	client.entries["j1"] = api.Entries{
+		newEntry(t, "j1", "e1", api.ActionAdd, "item1"),
+		newEntry(t, "j1", "e2", api.ActionAdd, "item2"),
+	}
+
+	s := &failingStore{Store: memory.NewStore()}
+	c := New(s, client, testKey)
+	_, err := c.Sync()
+	require.NoError(t, err)
+
+	st, err := c.SyncState("j1")
+	require.NoError(t, err)
+	assert.Equal(t, "e2", st.LastEntryUID)
+	assert.Equal(t, 2, st.Entries)
+	assert.Equal(t, "", st.Error)
+	assert.Equal(t, st.LastAttempt, st.LastSuccess)
+	assert.WithinDuration(t, time.Now(), st.LastSuccess, time.Minute)
+
+	t.Run("failure", func(t *testing.T) {
+		client.entries["j1"] = append(client.entries["j1"], newEntry(t, "j1", "e3", api.ActionAdd, "item3"))
+		s.fail = failEntry("e3")
+		defer func() { s.fail = nil }()
+		_, err := c.Sync()
+		require.Error(t, err)
+
+		failed, err := c.SyncState("j1")
+		require.NoError(t, err)
+		assert.NotEqual(t, "", failed.Error)
+		assert.Equal(t, 0, failed.Entries)
+		// the last success is kept
+		assert.Equal(t, "e2", failed.LastEntryUID)
+		assert.Equal(t, st.LastSuccess, failed.LastSuccess)
+		assert.False(t, failed.LastAttempt.Before(failed.LastSuccess))
+
+		states, err := c.SyncStates()
+		require.NoError(t, err)
+		assert.Len(t, states, 1)
+	})
+}
+
+func TestSyncRemoved(t *testing.T) {
+	client := newFakeClient()
+	client.journals = api.Journals{&api.Journal{UID: "j1"}, &api.Journal{UID: "j2"}}
+	client.entries["j2"] = api.Entries{newEntry(t, "j2", "e1", api.ActionAdd, "item1")}
+	available := client.journals
+
+	t.Run("archive", func(t *testing.T) {
+		client.journals = available
+		c := newTestCache(t, client)
+
+		_, err := c.Sync()
+		require.NoError(t, err)
+
+		client.journals = available[:1]
+		report, err := c.Sync()
+		require.NoError(t, err)
+		require.Len(t, report.Removed, 1)
+		assert.Equal(t, "j2", report.Removed[0].UID)
+
+		js, err := c.Journals()
+		require.NoError(t, err)
+		assert.Equal(t, available[:1], js)
+
+		archived, err := c.ArchivedJournals()
+		require.NoError(t, err)
+		assert.Equal(t, available[1:], archived)
+
+		_, err = c.Item("j2", "item1")
+		assert.NoError(t, err)
+
+		// removals are reported once
+		report, err = c.Sync()
+		require.NoError(t, err)
+		assert.Len(t, report.Removed, 0)
+
+		t.Run("restored", func(t *testing.T) {
+			client.journals = available
+			_, err := c.Sync()
+			require.NoError(t, err)
+
+			js, err := c.Journals()
+			require.NoError(t, err)
+			assert.Equal(t, available, js)
+		})
+	})
+
+	t.Run("purge", func(t *testing.T) {
+		client.journals = available
+		c := New(memory.NewStore(), client, testKey, WithRemovalPolicy(PurgeRemoved))
+
+		_, err := c.Sync()
+		require.NoError(t, err)
+
+		client.journals = available[:1]
+		report, err := c.Sync()
+		require.NoError(t, err)
+		assert.Len(t, report.Removed, 1)
+
+		archived, err := c.ArchivedJournals()
+		require.NoError(t, err)
+		assert.Len(t, archived, 0)
+
+		_, err = c.Item("j2", "item1")
+		assert.Equal(t, store.ErrRecordNotFound, err)
+	})
+}
+
+func TestPurge(t *testing.T) {
+	client := newFakeClient()
+	client.journals = api.Journals{&api.Journal{UID: "j1"}}
+	client.entries["j1"] = api.Entries{newEntry(t, "j1", "e1", api.ActionAdd, "item1")}
+
+	s := memory.NewStore()
+	a := New(s.ForAccount("a"), client, testKey)
+	b := New(s.ForAccount("b"), client, testKey)
+	for _, c := range []*Cache{a, b} {
+		_, err := c.Sync()
+		require.NoError(t, err)
+	}
+
+	require.NoError(t, a.Purge())
+
+	accounts, err := s.Accounts()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"b"}, accounts)
+
+	states, err := a.SyncStates()
+	require.NoError(t, err)
+	assert.Len(t, states, 0)
+
+	_, err = b.Item("j1", "item1")
+	assert.NoError(t, err)
+}
+
+func TestCompact(t *testing.T) {
+	client := newFakeClient()
+	client.journals = api.Journals{&api.Journal{UID: "j1"}}
+	client.entries["j1"] = api.Entries{
+		newEntry(t, "j1", "e1", api.ActionAdd, "item1"),
+		newEntry(t, "j1", "e2", api.ActionAdd, "item2"),
+		newEntry(t, "j1", "e3", api.ActionChange, "item1"),
+	}
+
+	c := newTestCache(t, client)
+
+	_, err := c.Sync()
+	require.NoError(t, err)
+
+	n, err := c.Compact(store.Retention{})
+	require.NoError(t, err)
+	assert.Equal(t, 2, n)
+
+	items, err := c.Items("j1")
+	require.NoError(t, err)
+	assert.Len(t, items, 2)
+
+	t.Run("syncs", func(t *testing.T) {
+		client.entries["j1"] = append(client.entries["j1"], newEntry(t, "j1", "e4", api.ActionDelete, "item2"))
+		_, err := c.Sync()
+		require.NoError(t, err)
+
+		es, err := c.JournalEntries("j1")
+		require.NoError(t, err)
+		assert.Equal(t, client.entries["j1"][2:], es)
+
+		items, err := c.Items("j1")
+		require.NoError(t, err)
+		assert.Len(t, items, 1)
+	})
+
+	t.Run("waits for a sync", func(t *testing.T) {
+		client.entries["j1"] = append(client.entries["j1"], newEntry(t, "j1", "e5", api.ActionAdd, "item3"))
+
+		fetching, release := make(chan struct{}), make(chan struct{})
+		client.onFetch = func(string) {
+			close(fetching)
+			<-release
+		}
+		defer func() { client.onFetch = nil }()
+
+		synced := make(chan error)
+		go func() {
+			_, err := c.Sync()
+			synced <- err
+		}()
+		<-fetching
+
+		compacted := make(chan int)
+		go func() {
+			n, err := c.Compact(store.Retention{})
+			assert.NoError(t, err)
+			compacted <- n
+		}()
+
+		select {
+		case <-compacted:
+			t.Fatal("compacted during the sync")
+		case <-time.After(50 * time.Millisecond):
+		}
+
+		close(release)
+		require.NoError(t, <-synced)
+		// the entries stored by the sync are compacted too
+		assert.Equal(t, 2, <-compacted)
+	})
+}
+
+func TestSearch(t *testing.T) {
+	client := newFakeClient()
+	client.journals = api.Journals{&api.Journal{UID: "j1"}}
+	client.entries["j1"] = api.Entries{
+		newEntry(t, "j1", "e1", api.ActionAdd, "item1"),
+		newEntry(t, "j1", "e2", api.ActionAdd, "item2"),
+		newEntry(t, "j1", "e3", api.ActionChange, "item1"),
+		newEntry(t, "j1", "e4", api.ActionDelete, "item2"),
+	}
+
+	s := memory.NewStore()
+	idx := memory.NewIndex()
+	c := New(s, client, testKey, WithIndex(idx))
+	_, err := c.Sync()
+	require.NoError(t, err)
+
+	search := func(c *Cache, query string) []string {
+		docs, err := c.Search(query, store.SearchFilter{})
+		require.NoError(t, err)
+
+		var uids []string
+		for _, doc := range docs {
+			assert.Equal(t, KindContact, doc.Kind)
+			uids = append(uids, doc.ItemUID)
+		}
+		return uids
+	}
+
+	assert.Equal(t, []string{"item1"}, search(c, "e3"))
+	assert.Nil(t, search(c, "e1"))
+	assert.Nil(t, search(c, "e2"))
+
+	t.Run("Reindex", func(t *testing.T) {
+		c := New(s, client, testKey, WithIndex(memory.NewIndex()))
+		assert.Nil(t, search(c, "e3"))
+
+		require.NoError(t, c.Reindex())
+		assert.Equal(t, []string{"item1"}, search(c, "e3"))
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		c := New(s, client, testKey)
+		_, err := c.Search("e3", store.SearchFilter{})
+		assert.Equal(t, ErrNoIndex, err)
+	})
+}
+
+func TestPush(t *testing.T) {
+	client := newFakeClient()
+	client.journals = api.Journals{&api.Journal{UID: "j1"}}
+	client.entries["j1"] = api.Entries{
+		newEntry(t, "j1", "e1", api.ActionAdd, "item1"),
+	}
+
+	c := newTestCache(t, client)
+
+	_, err := c.Sync()
+	require.NoError(t, err)
+
+	require.NoError(t, c.Put("j1", &pim.Contact{UID: "item2", FormattedName: "new"}))
+	require.NoError(t, c.Put("j1", &pim.Contact{UID: "item1", FormattedName: "changed"}))
+	require.NoError(t, c.Delete("j1", "item2"))
+	assert.Equal(t, store.ErrRecordNotFound, c.Delete("j1", "item2"))
+
+	// local changes are visible before being pushed
+	items, err := c.Items("j1")
+	require.NoError(t, err)
+	require.Len(t, items, 1)
+	assert.Equal(t, "item1", items[0].UID)
+	assert.Len(t, client.entries["j1"], 1)
+
+	_, err = c.Sync()
+	require.NoError(t, err)
+
+	pending, err := c.store.PendingChanges("j1")
+	require.NoError(t, err)
+	assert.Len(t, pending, 0)
+
+	es, err := c.JournalEntries("j1")
+	require.NoError(t, err)
+	require.Len(t, es, 4)
+	assert.Equal(t, client.entries["j1"], es)
+
+	cipher := crypto.New([]byte("j1"), testKey)
+	actions := []string{api.ActionAdd, api.ActionAdd, api.ActionChange, api.ActionDelete}
+	for i, e := range es {
+		content, err := e.GetContent(cipher)
+		require.NoError(t, err)
+		assert.Equal(t, actions[i], content.Action)
+	}
+
+	st, err := c.SyncState("j1")
+	require.NoError(t, err)
+	assert.Equal(t, es[3].UID, st.LastEntryUID)
+
+	t.Run("read-only", func(t *testing.T) {
+		client.journals[0].ReadOnly = true
+		_, err := c.Sync()
+		require.NoError(t, err)
+
+		err = c.Put("j1", &pim.Contact{UID: "item3"})
+		assert.Equal(t, ErrReadOnly, err)
+	})
+}
+
+func TestPushIsIdempotent(t *testing.T) {
+	client := newFakeClient()
+	client.journals = api.Journals{&api.Journal{UID: "j1"}}
+	client.entries["j1"] = api.Entries{newEntry(t, "j1", "e1", api.ActionAdd, "item1")}
+
+	// the pushed changes must not be taken for conflicts
+	var conflicts int
+	resolver := ResolverFunc(func(c *Conflict) (pim.Item, error) {
+		conflicts++
+		return c.Local, nil
+	})
+
+	s := &failingStore{Store: memory.NewStore()}
+	c := New(s, client, testKey, WithResolver(resolver))
+	_, err := c.Sync()
+	require.NoError(t, err)
+
+	require.NoError(t, c.Put("j1", &pim.Contact{UID: "item2", FormattedName: "new"}))
+
+	// the entries are created on the server but not stored
+	s.fail = func(*api.Entry) bool { return true }
+	_, err = c.Sync()
+	require.Error(t, err)
+	require.Len(t, client.entries["j1"], 2)
+
+	s.fail = nil
+	_, err = c.Sync()
+	require.NoError(t, err)
+	assert.Len(t, client.entries["j1"], 2)
+	assert.Equal(t, 0, conflicts)
+
+	pending, err := c.store.PendingChanges("j1")
+	require.NoError(t, err)
+	assert.Len(t, pending, 0)
+
+	es, err := c.JournalEntries("j1")
+	require.NoError(t, err)
+	assert.Equal(t, client.entries["j1"], es)
+
+	item, err := c.Item("j1", "item2")
+	require.NoError(t, err)
+	assert.Equal(t, es[1].UID, item.Entry.UID)
+}
+
+func TestPushKeepsContent(t *testing.T) {
+	const recurring = "BEGIN:VCALENDAR\r\n" +
+		"VERSION:2.0\r\n" +
+		"BEGIN:VEVENT\r\n" +
+		"UID:event1\r\n" +
+		"DTSTAMP:20200102T030405Z\r\n" +
+		"DTSTART;TZID=Europe/Berlin:20200110T100000\r\n" +
+		"SUMMARY:Standup\r\n" +
+		"RRULE:FREQ=WEEKLY;BYDAY=MO,WE,FR\r\n" +
+		"ATTENDEE;CN=Jane Roe;PARTSTAT=ACCEPTED:mailto:jane@example.com\r\n" +
+		"BEGIN:VALARM\r\n" +
+		"ACTION:DISPLAY\r\n" +
+		"TRIGGER:-PT15M\r\n" +
+		"END:VALARM\r\n" +
+		"END:VEVENT\r\n" +
+		"END:VCALENDAR\r\n"
+
+	cipher := crypto.New([]byte("j1"), testKey)
+	e := &api.Entry{UID: "e1"}
+	require.NoError(t, e.SetContent(&api.EntryContent{Action: api.ActionAdd, Content: recurring}, cipher))
+
+	client := newFakeClient()
+	client.journals = api.Journals{&api.Journal{UID: "j1"}}
+	client.entries["j1"] = api.Entries{e}
+
+	c := newTestCache(t, client)
+
+	_, err := c.Sync()
+	require.NoError(t, err)
+
+	item, err := c.Item("j1", "event1")
+	require.NoError(t, err)
+	content, err := item.Entry.GetContent(cipher)
+	require.NoError(t, err)
+	parsed, err := pim.FromEntry(content)
+	require.NoError(t, err)
+
+	ev := parsed.(*pim.Event)
+	ev.Summary = "Daily standup"
+	require.NoError(t, c.Put("j1", ev))
+	_, err = c.Sync()
+	require.NoError(t, err)
+
+	es := client.entries["j1"]
+	require.Len(t, es, 2)
+	content, err = es[1].GetContent(cipher)
+	require.NoError(t, err)
+	assert.Equal(t, api.ActionChange, content.Action)
+	assert.Equal(t, strings.Replace(recurring, "SUMMARY:Standup", "SUMMARY:Daily standup", 1), content.Content)
+}
+
+func TestPushConflict(t *testing.T) {
+	client := newFakeClient()
+	client.journals = api.Journals{&api.Journal{UID: "j1"}}
+	client.entries["j1"] = api.Entries{
+		newEntry(t, "j1", "e1", api.ActionAdd, "item1"),
+	}
+
+	c := newTestCache(t, client)
+
+	_, err := c.Sync()
+	require.NoError(t, err)
+
+	require.NoError(t, c.Put("j1", &pim.Contact{UID: "item2", FormattedName: "local"}))
+
+	// another client pushes right before us
+	client.onCreate = func(uid string) {
+		client.onCreate = nil
+		client.entries[uid] = append(client.entries[uid], newEntry(t, uid, "e2", api.ActionAdd, "item3"))
+	}
+
+	_, err = c.Sync()
+	require.NoError(t, err)
+
+	es, err := c.JournalEntries("j1")
+	require.NoError(t, err)
+	require.Len(t, es, 3)
+	assert.Equal(t, client.entries["j1"], es)
+	assert.Equal(t, "e2", es[1].UID)
+
+	items, err := c.Items("j1")
+	require.NoError(t, err)
+	uids := make([]string, len(items))
+	for i, item := range items {
+		uids[i] = item.UID
+	}
+	assert.ElementsMatch(t, []string{"item1", "item2", "item3"}, uids)
+
+	t.Run("gives up", func(t *testing.T) {
+		require.NoError(t, c.Put("j1", &pim.Contact{UID: "item4"}))
+
+		n := 0
+		client.onCreate = func(uid string) {
+			n++
+			client.entries[uid] = append(client.entries[uid], newEntry(t, uid, fmt.Sprintf("c%d", n), api.ActionAdd, "item5"))
+		}
+		defer func() { client.onCreate = nil }()
+
+		_, err := c.Sync()
+		require.IsType(t, SyncError{}, err)
+		assert.Equal(t, api.ErrConflict, err.(SyncError)[0].Err)
+		assert.True(t, errors.Is(err, api.ErrConflict))
+		assert.Equal(t, maxPushRetries+1, n)
+
+		// the change is kept on top of the pulled entries
+		item, err := c.Item("j1", "item4")
+		require.NoError(t, err)
+		assert.NotNil(t, item)
+
+		pending, err := c.store.PendingChanges("j1")
+		require.NoError(t, err)
+		assert.Len(t, pending, 1)
+	})
+}
+
+func TestConflicts(t *testing.T) {
+	rev := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	base := &pim.Contact{UID: "item1", FormattedName: "base", Revision: rev}
+	local := &pim.Contact{UID: "item1", FormattedName: "base", Note: "local", Revision: rev.Add(2 * time.Hour)}
+	remote := &pim.Contact{UID: "item1", FormattedName: "remote", Revision: rev.Add(time.Hour)}
+	merged := &pim.Contact{UID: "item1", FormattedName: "remote", Note: "local", Revision: rev.Add(2 * time.Hour)}
+	unresolved := ResolverFunc(func(*Conflict) (pim.Item, error) {
+		return nil, ErrUnresolved
+	})
+
+	tests := []struct {
+		name      string
+		resolver  Resolver
+		expected  *pim.Contact
+		conflicts int
+	}{
+		{"server wins", ServerWins, remote, 0},
+		{"client wins", ClientWins, local, 0},
+		{"newest wins", NewestWins, local, 0},
+		{"merge", Merge, merged, 0},
+		{"unresolved", unresolved, remote, 1},
+	}
+
+	cipher := crypto.New([]byte("j1"), testKey)
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			client := newFakeClient()
+			client.journals = api.Journals{&api.Journal{UID: "j1"}}
+			client.entries["j1"] = api.Entries{newItemEntry(t, "j1", "e1", api.ActionAdd, base)}
+
+			c := New(memory.NewStore(), client, testKey, WithResolver(test.resolver))
+			_, err := c.Sync()
+			require.NoError(t, err)
+
+			require.NoError(t, c.Put("j1", local))
+			client.entries["j1"] = append(client.entries["j1"], newItemEntry(t, "j1", "e2", api.ActionChange, remote))
+
+			_, err = c.Sync()
+			require.NoError(t, err)
+
+			item, err := c.Item("j1", "item1")
+			require.NoError(t, err)
+			content, err := item.Entry.GetContent(cipher)
+			require.NoError(t, err)
+			assertItem(t, test.expected, content.Content)
+
+			// the server ends up with the kept item
+			es := client.entries["j1"]
+			content, err = es[len(es)-1].GetContent(cipher)
+			require.NoError(t, err)
+			assertItem(t, test.expected, content.Content)
+
+			pending, err := c.store.PendingChanges("j1")
+			require.NoError(t, err)
+			assert.Len(t, pending, 0)
+
+			conflicts, err := c.Conflicts("j1")
+			require.NoError(t, err)
+			assert.Len(t, conflicts, test.conflicts)
+		})
+	}
+
+	t.Run("server wins by default", func(t *testing.T) {
+		client := newFakeClient()
+		client.journals = api.Journals{&api.Journal{UID: "j1"}}
+		client.entries["j1"] = api.Entries{newItemEntry(t, "j1", "e1", api.ActionAdd, base)}
+
+		c := New(memory.NewStore(), client, testKey)
+		_, err := c.Sync()
+		require.NoError(t, err)
+
+		require.NoError(t, c.Put("j1", local))
+		client.entries["j1"] = append(client.entries["j1"], newItemEntry(t, "j1", "e2", api.ActionChange, remote))
+		report, err := c.Sync()
+		require.NoError(t, err)
+
+		assert.Len(t, client.entries["j1"], 2)
+		item, err := c.Item("j1", "item1")
+		require.NoError(t, err)
+		assert.Equal(t, "e2", item.Entry.UID)
+
+		// the dropped local change is reported
+		require.Len(t, report.Discarded, 1)
+		assert.Equal(t, "j1", report.Discarded[0].JournalUID)
+		assert.Equal(t, "item1", report.Discarded[0].ItemUID)
+		assertItem(t, local, report.Discarded[0].Local.Encode())
+	})
+
+	t.Run("the kept side is pushed as is", func(t *testing.T) {
+		client := newFakeClient()
+		client.journals = api.Journals{&api.Journal{UID: "j1"}}
+		client.entries["j1"] = api.Entries{newItemEntry(t, "j1", "e1", api.ActionAdd, base)}
+
+		c := New(memory.NewStore(), client, testKey, WithResolver(ClientWins))
+		_, err := c.Sync()
+		require.NoError(t, err)
+
+		// the local change isn't encoded by pim
+		change := &api.Entry{UID: "local"}
+		vcard := "BEGIN:VCARD\r\nVERSION:3.0\r\nUID:item1\r\nFN:local\r\nX-CUSTOM:kept\r\nEND:VCARD\r\n"
+		require.NoError(t, change.SetContent(&api.EntryContent{Action: api.ActionChange, Content: vcard}, cipher))
+		require.NoError(t, c.store.EnqueueChange(&store.Change{JournalUID: "j1", Entry: change, CreatedAt: time.Now()}))
+
+		client.entries["j1"] = append(client.entries["j1"], newItemEntry(t, "j1", "e2", api.ActionChange, remote))
+		report, err := c.Sync()
+		require.NoError(t, err)
+		assert.Empty(t, report.Discarded)
+
+		es := client.entries["j1"]
+		require.Len(t, es, 3)
+		content, err := es[2].GetContent(cipher)
+		require.NoError(t, err)
+		assert.Equal(t, &api.EntryContent{Action: api.ActionChange, Content: vcard}, content)
+	})
+
+	t.Run("resolve manually", func(t *testing.T) {
+		client := newFakeClient()
+		client.journals = api.Journals{&api.Journal{UID: "j1"}}
+		client.entries["j1"] = api.Entries{newItemEntry(t, "j1", "e1", api.ActionAdd, base)}
+
+		c := New(memory.NewStore(), client, testKey, WithResolver(Merge))
+		_, err := c.Sync()
+		require.NoError(t, err)
+
+		// both sides change the same property
+		require.NoError(t, c.Put("j1", &pim.Contact{UID: "item1", FormattedName: "local"}))
+		client.entries["j1"] = append(client.entries["j1"], newItemEntry(t, "j1", "e2", api.ActionChange, remote))
+
+		_, err = c.Sync()
+		require.NoError(t, err)
+
+		conflicts, err := c.Conflicts("")
+		require.NoError(t, err)
+		require.Len(t, conflicts, 1)
+		assert.Equal(t, "item1", conflicts[0].ItemUID)
+		assert.Equal(t, "e2", conflicts[0].Remote.UID)
+
+		require.NoError(t, c.ResolveConflict(conflicts[0], merged))
+
+		conflicts, err = c.Conflicts("")
+		require.NoError(t, err)
+		assert.Len(t, conflicts, 0)
+
+		_, err = c.Sync()
+		require.NoError(t, err)
+
+		item, err := c.Item("j1", "item1")
+		require.NoError(t, err)
+		content, err := item.Entry.GetContent(cipher)
+		require.NoError(t, err)
+		assertItem(t, merged, content.Content)
+	})
+}
+
+func TestProgress(t *testing.T) {
+	client := newFakeClient()
+	client.journals = api.Journals{
+		&api.Journal{UID: "j1"},
+		&api.Journal{UID: "j2"},
+	}
+	client.entries["j1"] = api.Entries{
+		newEntry(t, "j1", "e1", api.ActionAdd, "item1"),
+		newEntry(t, "j1", "e2", api.ActionAdd, "item2"),
+	}
+
+	var events []ProgressEvent
+	c := New(memory.NewStore(), client, testKey, WithObserver(func(ev ProgressEvent) {
+		events = append(events, ev)
+	}))
+
+	_, err := c.Sync()
+	require.NoError(t, err)
+
+	assert.Equal(t, []ProgressEvent{
+		{Kind: JournalStarted, JournalUID: "j1", Journal: 1, Journals: 2},
+		{Kind: EntriesFetched, JournalUID: "j1", Journal: 1, Journals: 2, Entries: 2},
+		{Kind: JournalFinished, JournalUID: "j1", Journal: 1, Journals: 2},
+		{Kind: JournalStarted, JournalUID: "j2", Journal: 2, Journals: 2},
+		{Kind: JournalFinished, JournalUID: "j2", Journal: 2, Journals: 2},
+	}, events)
+
+	t.Run("pushed", func(t *testing.T) {
+		events = nil
+		require.NoError(t, c.Put("j1", &pim.Contact{UID: "item3"}))
+		require.NoError(t, c.SyncJournal("j1"))
+
+		assert.Equal(t, []ProgressEvent{
+			{Kind: JournalStarted, JournalUID: "j1", Journal: 1, Journals: 1},
+			{Kind: EntriesPushed, JournalUID: "j1", Journal: 1, Journals: 1, Entries: 1},
+			{Kind: JournalFinished, JournalUID: "j1", Journal: 1, Journals: 1},
+		}, events)
+	})
+
+	t.Run("failed", func(t *testing.T) {
+		events = nil
+		client.fetchErrs = map[string]error{"j2": errFetch}
+		_, err := c.Sync()
+		require.Error(t, err)
+
+		require.Len(t, events, 5)
+		assert.Equal(t, SyncFailed, events[3].Kind)
+		assert.Equal(t, "j2", events[3].JournalUID)
+		assert.Equal(t, err.(SyncError)[0].Err, events[3].Err)
+		assert.Equal(t, JournalFinished, events[4].Kind)
+		assert.Equal(t, events[3].Err, events[4].Err)
+	})
+
+	t.Run("stop", func(t *testing.T) {
+		var n int
+		stop := c.Observe(func(ProgressEvent) { n++ })
+		require.NoError(t, c.SyncJournal("j1"))
+		stop()
+		require.NoError(t, c.SyncJournal("j1"))
+		assert.Equal(t, 2, n)
+	})
+}
+
+func TestSyncPartialFailure(t *testing.T) {
+	client := newFakeClient()
+	client.journals = api.Journals{
+		&api.Journal{UID: "j1"},
+		&api.Journal{UID: "j2"},
+		&api.Journal{UID: "j3"},
+	}
+	client.entries["j2"] = api.Entries{newEntry(t, "j2", "e1", api.ActionAdd, "item1")}
+	client.fetchErrs = map[string]error{"j1": errFetch, "j3": errFetch}
+
+	c := newTestCache(t, client)
+
+	_, err := c.Sync()
+	require.IsType(t, SyncError{}, err)
+
+	failed := err.(SyncError)
+	require.Len(t, failed, 2)
+	assert.Equal(t, "j1", failed[0].JournalUID)
+	assert.Equal(t, "j3", failed[1].JournalUID)
+	assert.Contains(t, err.Error(), "journal j1: ")
+	assert.True(t, errors.Is(err, errFetch))
+	assert.False(t, errors.Is(err, api.ErrConflict))
+
+	js, err := c.Journals()
+	require.NoError(t, err)
+	require.Len(t, js, 1)
+	assert.Equal(t, "j2", js[0].UID)
+
+	t.Run("fail fast", func(t *testing.T) {
+		c := New(memory.NewStore(), client, testKey, WithFailFast())
+		_, err := c.Sync()
+		require.IsType(t, SyncError{}, err)
+		assert.Len(t, err.(SyncError), 1)
+
+		js, err := c.Journals()
+		require.NoError(t, err)
+		assert.Len(t, js, 0)
+	})
+}
+
+func TestSyncConcurrency(t *testing.T) {
+	client := newFakeClient()
+	for i := 0; i < 10; i++ {
+		uid := fmt.Sprintf("j%d", i)
+		client.journals = append(client.journals, &api.Journal{UID: uid})
+		client.entries[uid] = api.Entries{newEntry(t, uid, "e1", api.ActionAdd, "item1")}
+	}
+	client.fetchErrs = map[string]error{"j3": errFetch, "j7": errFetch}
+
+	var running, max int32
+	client.onFetch = func(string) {
+		n := atomic.AddInt32(&running, 1)
+		defer atomic.AddInt32(&running, -1)
+		for {
+			m := atomic.LoadInt32(&max)
+			if n <= m || atomic.CompareAndSwapInt32(&max, m, n) {
+				break
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	c := New(memory.NewStore(), client, testKey, WithConcurrency(4))
+	_, err := c.Sync()
+	require.IsType(t, SyncError{}, err)
+
+	failed := err.(SyncError)
+	require.Len(t, failed, 2)
+	assert.Equal(t, "j3", failed[0].JournalUID)
+	assert.Equal(t, "j7", failed[1].JournalUID)
+
+	js, err := c.Journals()
+	require.NoError(t, err)
+	assert.Len(t, js, 8)
+	for _, j := range js {
+		item, err := c.Item(j.UID, "item1")
+		require.NoError(t, err)
+		assert.Equal(t, "e1", item.Entry.UID)
+	}
+
+	assert.True(t, max > 1, "journals should be synced concurrently")
+	assert.True(t, max <= 4, "at most 4 journals should be synced at once")
+}
+
+func TestSubscribe(t *testing.T) {
+	client := newFakeClient()
+	client.journals = api.Journals{
+		&api.Journal{UID: "j1"},
+		&api.Journal{UID: "j2"},
+	}
+	client.entries["j1"] = api.Entries{
+		newEntry(t, "j1", "e1", api.ActionAdd, "item1"),
+		newEntry(t, "j1", "e2", api.ActionAdd, "item2"),
+		newEntry(t, "j1", "e3", api.ActionChange, "item1"),
+	}
+
+	c := newTestCache(t, client)
+
+	ch, cancel := c.Subscribe(10)
+	_, err := c.Sync()
+	require.NoError(t, err)
+
+	require.Len(t, ch, 1)
+	n := <-ch
+	assert.Equal(t, "j1", n.JournalUID)
+	assert.Equal(t, client.entries["j1"], n.Entries)
+	assert.Equal(t, []string{"item1", "item2"}, n.ItemUIDs)
+
+	t.Run("unchanged", func(t *testing.T) {
+		_, err := c.Sync()
+		require.NoError(t, err)
+		assert.Len(t, ch, 0)
+	})
+
+	t.Run("pulled and pushed", func(t *testing.T) {
+		client.entries["j2"] = api.Entries{newEntry(t, "j2", "e1", api.ActionAdd, "item3")}
+		require.NoError(t, c.Put("j2", &pim.Contact{UID: "item4"}))
+
+		_, err := c.Sync()
+		require.NoError(t, err)
+
+		require.Len(t, ch, 1)
+		n := <-ch
+		assert.Equal(t, "j2", n.JournalUID)
+		assert.Len(t, n.Entries, 2)
+		assert.Equal(t, []string{"item3", "item4"}, n.ItemUIDs)
+	})
+
+	t.Run("cancel", func(t *testing.T) {
+		cancel()
+		cancel()
+		_, ok := <-ch
+
+		assert.False(t, ok, "channel should be closed")
+
+		client.entries["j1"] = append(client.entries["j1"], newEntry(t, "j1", "e4", api.ActionAdd, "item5"))
+		_, err := c.Sync()
+		require.NoError(t, err)
+	})
+}
+
+func TestAutoSync(t *testing.T) {
+	client := newFakeClient()
+	client.journals = api.Journals{&api.Journal{UID: "j1"}}
+	client.entries["j1"] = api.Entries{newEntry(t, "j1", "e1", api.ActionAdd, "item1")}
+
+	c := newTestCache(t, client)
+
+	ch, cancel := c.Subscribe(0)
+	defer cancel()
+
+	stop := c.StartAutoSync(10*time.Millisecond, 5*time.Millisecond)
+	defer stop()
+
+	select {
+	case n := <-ch:
+		assert.Equal(t, "j1", n.JournalUID)
+		assert.Equal(t, []string{"item1"}, n.ItemUIDs)
+	case <-time.After(time.Second):
+		t.Fatal("timeout waiting for the background sync")
+	}
+
+	stop()
+	stop()
+}
+
+func TestOffline(t *testing.T) {
+	client := newFakeClient()
+	client.journals = api.Journals{&api.Journal{UID: "j1"}}
+	client.entries["j1"] = api.Entries{newEntry(t, "j1", "e1", api.ActionAdd, "item1")}
+
+	s := memory.NewStore()
+	online := New(s, client, testKey)
+
+	last, err := online.LastSync()
+	require.NoError(t, err)
+	assert.True(t, last.IsZero())
+
+	_, err = online.Sync()
+	require.NoError(t, err)
+
+	last, err = online.LastSync()
+	require.NoError(t, err)
+	assert.WithinDuration(t, time.Now(), last, time.Minute)
+
+	c := New(s, nil, testKey)
+	_, err = c.Sync()
+	assert.Equal(t, ErrOffline, err)
+	assert.Equal(t, ErrOffline, c.SyncJournal("j1"))
+
+	js, err := c.Journals()
+	require.NoError(t, err)
+	assert.Len(t, js, 1)
+
+	require.NoError(t, c.Put("j1", &pim.Contact{UID: "item2"}))
+	items, err := c.Items("j1")
+	require.NoError(t, err)
+	assert.Len(t, items, 2)
+
+	// changes made offline are pushed by the next online sync
+	_, err = online.Sync()
+	require.NoError(t, err)
+	assert.Len(t, client.entries["j1"], 2)
+}
+
+func TestVerify(t *testing.T) {
+	client := newFakeClient()
+	client.journals = api.Journals{&api.Journal{UID: "j1"}}
+	client.entries["j1"] = api.Entries{
+		newEntry(t, "j1", "e1", api.ActionAdd, "item1"),
+		newEntry(t, "j1", "e2", api.ActionAdd, "item2"),
+		newEntry(t, "j1", "e3", api.ActionAdd, "item3"),
+	}
+
+	c := newTestCache(t, client)
+
+	_, err := c.Sync()
+	require.NoError(t, err)
+
+	v, err := c.Verify("j1")
+	require.NoError(t, err)
+	assert.True(t, v.OK())
+	assert.Equal(t, "ok", v.String())
+
+	t.Run("compacted", func(t *testing.T) {
+		_, err := c.Compact(store.Retention{Count: 2})
+		require.NoError(t, err)
+
+		v, err := c.Verify("j1")
+		require.NoError(t, err)
+		assert.True(t, v.OK())
+		assert.Equal(t, 1, v.Compacted)
+	})
+
+	t.Run("missing history", func(t *testing.T) {
+		local := client.entries["j1"][1:]
+		v := verify("j1", local, client.entries["j1"], "")
+		assert.False(t, v.OK())
+		assert.Equal(t, []string{"e1"}, v.Missing)
+		assert.Equal(t, 0, v.Compacted)
+
+		// the kept entries which aren't stored are missing
+		v = verify("j1", local[1:], client.entries["j1"], "e2")
+		assert.Equal(t, []string{"e2"}, v.Missing)
+		assert.Equal(t, 1, v.Compacted)
+	})
+
+	// the server history is rewritten
+	client.entries["j1"] = api.Entries{
+		client.entries["j1"][0],
+		newEntry(t, "j1", "e2", api.ActionAdd, "item4"),
+		newEntry(t, "j1", "e4", api.ActionAdd, "item5"),
+	}
+
+	v, err = c.Verify("j1")
+	require.NoError(t, err)
+	assert.False(t, v.OK())
+	assert.Equal(t, []string{"e4"}, v.Missing)
+	assert.Equal(t, []string{"e3"}, v.Extra)
+	assert.Equal(t, []string{"e2"}, v.Diverging)
+
+	t.Run("repair", func(t *testing.T) {
+		require.NoError(t, c.Put("j1", &pim.Contact{UID: "item6"}))
+		require.NoError(t, c.Repair("j1"))
+
+		v, err := c.Verify("j1")
+		require.NoError(t, err)
+		assert.True(t, v.OK())
+		assert.Equal(t, 0, v.Compacted)
+
+		items, err := c.Items("j1")
+		require.NoError(t, err)
+		uids := make([]string, len(items))
+		for i, item := range items {
+			uids[i] = item.UID
+		}
+		assert.Equal(t, []string{"item1", "item4", "item5", "item6"}, uids)
+
+		// the pending change is kept
+		_, err = c.Sync()
+		require.NoError(t, err)
+		assert.Len(t, client.entries["j1"], 4)
+	})
+
+	t.Run("offline", func(t *testing.T) {
+		c := New(memory.NewStore(), nil, testKey)
+		_, err := c.Verify("j1")
+		assert.Equal(t, ErrOffline, err)
+		assert.Equal(t, ErrOffline, c.Repair("j1"))
+	})
+}
+
+func TestSyncFilter(t *testing.T) {
+	newJournal := func(typ api.JournalType, name string, selected bool) *api.Journal {
+		j, err := api.NewJournal(typ, name, 0, testKey)
+		require.NoError(t, err)
+		if !selected {
+			cipher := crypto.New([]byte(j.UID), testKey)
+			content, err := j.GetContent(cipher)
+			require.NoError(t, err)
+			content.Selected = false
+			require.NoError(t, j.SetContent(content, cipher))
+		}
+		return j
+	}
+
+	client := newFakeClient()
+	client.journals = api.Journals{
+		newJournal(api.JournalCalendar, "Work", true),
+		newJournal(api.JournalAddressBook, "Contacts", true),
+		newJournal(api.JournalAddressBook, "Old contacts", false),
+		newJournal(api.JournalTasks, "Todo", true),
+	}
+	uids := func(js api.Journals) []string {
+		var uids []string
+		for _, j := range js {
+			uids = append(uids, j.UID)
+		}
+		return uids
+	}
+	all := uids(client.journals)
+
+	tests := []struct {
+		name     string
+		filter   SyncFilter
+		expected []string
+	}{
+		{"none", SyncFilter{}, all},
+		{"types", SyncFilter{Types: []api.JournalType{api.JournalAddressBook, api.JournalTasks}}, all[1:]},
+		{"selected", SyncFilter{OnlySelected: true}, []string{all[0], all[1], all[3]}},
+		{"allow", SyncFilter{Allow: []string{"work", all[3]}}, []string{all[0], all[3]}},
+		{"deny", SyncFilter{Allow: []string{"Work", "Todo"}, Deny: []string{all[3]}}, all[:1]},
+		{"combined", SyncFilter{Types: []api.JournalType{api.JournalAddressBook}, OnlySelected: true}, all[1:2]},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := New(memory.NewStore(), client, testKey, WithSyncFilter(test.filter))
+			report, err := c.Sync()
+			require.NoError(t, err)
+
+			js, err := c.Journals()
+			require.NoError(t, err)
+			assert.Equal(t, test.expected, uids(js))
+			assert.Len(t, report.Skipped, len(all)-len(test.expected))
+		})
+	}
+
+	t.Run("skipped journals are not archived", func(t *testing.T) {
+		s := memory.NewStore()
+		_, err := New(s, client, testKey).Sync()
+		require.NoError(t, err)
+
+		c := New(s, client, testKey, WithSyncFilter(SyncFilter{Types: []api.JournalType{api.JournalCalendar}}))
+		report, err := c.Sync()
+		require.NoError(t, err)
+		assert.Len(t, report.Removed, 0)
+
+		js, err := c.Journals()
+		require.NoError(t, err)
+		assert.Len(t, js, len(all))
+	})
+
+	t.Run("last sync ignores skipped journals", func(t *testing.T) {
+		s := memory.NewStore()
+		_, err := New(s, client, testKey).Sync()
+		require.NoError(t, err)
+
+		old := time.Now().Add(-time.Hour)
+		for _, uid := range all {
+			st, err := s.SyncState(uid)
+			require.NoError(t, err)
+			st.LastSuccess = old
+			require.NoError(t, s.PutSyncState(st))
+		}
+
+		start := time.Now()
+		c := New(s, client, testKey, WithSyncFilter(SyncFilter{Types: []api.JournalType{api.JournalCalendar}}))
+		_, err = c.Sync()
+		require.NoError(t, err)
+
+		last, err := c.LastSync()
+		require.NoError(t, err)
+		assert.False(t, last.Before(start))
+
+		last, err = New(s, client, testKey).LastSync()
+		require.NoError(t, err)
+		assert.WithinDuration(t, old, last, time.Millisecond)
+	})
+}
+
+func TestContentCache(t *testing.T) {
+	j, err := api.NewJournal(api.JournalTasks, "Todo", 0, testKey)
+	require.NoError(t, err)
+
+	client := newFakeClient()
+	client.journals = api.Journals{j}
+	client.entries[j.UID] = api.Entries{
+		newItemEntry(t, j.UID, "e1", api.ActionAdd, &pim.Task{UID: "t1", Summary: "buy milk"}),
+	}
+
+	c := New(memory.NewStore(), client, testKey)
+	_, err = c.Sync()
+	require.NoError(t, err)
+
+	t.Run("Decode", func(t *testing.T) {
+		e := client.entries[j.UID][0]
+		d, err := c.Decode(j.UID, e)
+		require.NoError(t, err)
+		assert.Equal(t, api.ActionAdd, d.Content.Action)
+		require.IsType(t, &pim.Task{}, d.Item)
+		assert.Equal(t, "buy milk", d.Item.(*pim.Task).Summary)
+
+		_, ok := c.contents.get(lruKey{journalUID: j.UID, uid: e.UID})
+		assert.True(t, ok, "expected the cached content")
+
+		// the cached content isn't changed through the returned copy
+		d.Content.Action = api.ActionDelete
+		d.Item.(*pim.Task).Summary = "buy bread"
+		d.Item.(*pim.Task).Categories = append(d.Item.(*pim.Task).Categories, "home")
+
+		again, err := c.Decode(j.UID, e)
+		require.NoError(t, err)
+		assert.Equal(t, api.ActionAdd, again.Content.Action)
+		assert.Equal(t, "buy milk", again.Item.(*pim.Task).Summary)
+		assert.Empty(t, again.Item.(*pim.Task).Categories)
+	})
+
+	t.Run("JournalContent is invalidated on sync", func(t *testing.T) {
+		content, err := c.JournalContent(j)
+		require.NoError(t, err)
+		assert.Equal(t, "Todo", content.DisplayName)
+
+		cipher := crypto.New([]byte(j.UID), testKey)
+		renamed := *j
+		content.DisplayName = "Chores"
+		require.NoError(t, renamed.SetContent(content, cipher))
+		client.journals = api.Journals{&renamed}
+
+		_, err = c.Sync()
+		require.NoError(t, err)
+
+		stored, err := c.Journal(j.UID)
+		require.NoError(t, err)
+		content, err = c.JournalContent(stored)
+		require.NoError(t, err)
+		assert.Equal(t, "Chores", content.DisplayName)
+	})
+
+	t.Run("Disabled", func(t *testing.T) {
+		c := New(memory.NewStore(), client, testKey, WithContentCache(0))
+		e := client.entries[j.UID][0]
+		d, err := c.Decode(j.UID, e)
+		require.NoError(t, err)
+
+		_, ok := c.contents.get(lruKey{journalUID: j.UID, uid: e.UID})
+		assert.False(t, ok)
+
+		again, err := c.Decode(j.UID, e)
+		require.NoError(t, err)
+		assert.Equal(t, d, again)
+	})
+}
+
+func TestLRU(t *testing.T) {
+	l := newLRU(10)
+	key := func(j, uid string) lruKey { return lruKey{journalUID: j, uid: uid} }
+
+	l.add(key("j1", "a"), "a", 4)
+	l.add(key("j1", "b"), "b", 4)
+	_, ok := l.get(key("j1", "a"))
+	require.True(t, ok)
+
+	// b is the least recently used
+	l.add(key("j2", "c"), "c", 4)
+	_, ok = l.get(key("j1", "b"))
+	assert.False(t, ok)
+	assert.Equal(t, 8, l.size)
+
+	// too big to be cached
+	l.add(key("j2", "d"), "d", 11)
+	_, ok = l.get(key("j2", "d"))
+	assert.False(t, ok)
+
+	l.removeJournal("j1")
+	_, ok = l.get(key("j1", "a"))
+	assert.False(t, ok)
+	v, ok := l.get(key("j2", "c"))
+	assert.True(t, ok)
+	assert.Equal(t, "c", v)
+	assert.Equal(t, 4, l.size)
+}
@@ -0,0 +1,367 @@
+package cache
+
+import (
+	"errors"
+	"reflect"
+	"time"
+
+	"github.com/gchaincl/go-etesync/api"
+	"github.com/gchaincl/go-etesync/crypto"
+	"github.com/gchaincl/go-etesync/pim"
+	"github.com/gchaincl/go-etesync/store"
+)
+
+var (
+	// ErrUnresolved is returned by a Resolver which can't resolve a
+	// conflict, the conflict is then recorded to be handled manually
+	ErrUnresolved = errors.New("conflict can't be resolved")
+)
+
+// Conflict is an item changed both by pending local changes and by entries
+// pulled from the server. A nil item was deleted, or didn't exist for Base.
+type Conflict struct {
+	JournalUID string
+	ItemUID    string
+	// Base is the item as of the previous sync, nil if it's unknown
+	Base   pim.Item
+	Local  pim.Item
+	Remote pim.Item
+}
+
+// Resolver decides which item is kept on a conflict
+type Resolver interface {
+	// Resolve returns the item to keep, nil to delete it, or ErrUnresolved
+	Resolve(c *Conflict) (pim.Item, error)
+}
+
+// ResolverFunc adapts a function into a Resolver
+type ResolverFunc func(*Conflict) (pim.Item, error)
+
+func (f ResolverFunc) Resolve(c *Conflict) (pim.Item, error) {
+	return f(c)
+}
+
+var (
+	// ServerWins keeps the item from the server dropping the local changes,
+	// which are reported in SyncReport.Discarded
+	ServerWins Resolver = ResolverFunc(func(c *Conflict) (pim.Item, error) {
+		return c.Remote, nil
+	})
+
+	// ClientWins keeps the local item overwriting the server changes
+	ClientWins Resolver = ResolverFunc(func(c *Conflict) (pim.Item, error) {
+		return c.Local, nil
+	})
+
+	// NewestWins keeps the item modified last according to its LAST-MODIFIED,
+	// or REV for contacts. The server wins ties and deletions.
+	NewestWins Resolver = ResolverFunc(func(c *Conflict) (pim.Item, error) {
+		if c.Local != nil && c.Remote != nil && lastModified(c.Local).After(lastModified(c.Remote)) {
+			return c.Local, nil
+		}
+		return c.Remote, nil
+	})
+
+	// Merge keeps the properties changed on each side since the base item.
+	// Properties changed differently on both sides and deletions can't be
+	// merged. The properties pim doesn't model are kept as the server has
+	// them.
+	Merge Resolver = ResolverFunc(merge)
+)
+
+// WithResolver sets how Sync resolves conflicts, the server wins by default
+func WithResolver(r Resolver) Option {
+	return func(c *Cache) { c.resolver = r }
+}
+
+// lastModified returns when an item was last modified, zero if unknown
+func lastModified(item pim.Item) time.Time {
+	switch item := item.(type) {
+	case *pim.Event:
+		return item.LastModified
+	case *pim.Task:
+		return item.LastModified
+	case *pim.Contact:
+		return item.Revision
+	}
+	return time.Time{}
+}
+
+// timestamps are the properties updated on every change, which merge sets to
+// the newest value instead of reporting a conflict
+var timestamps = map[string]bool{
+	"LastModified": true,
+	"Stamp":        true,
+	"Revision":     true,
+}
+
+func merge(c *Conflict) (pim.Item, error) {
+	if c.Base == nil || c.Local == nil || c.Remote == nil {
+		return nil, ErrUnresolved
+	}
+
+	base := reflect.ValueOf(c.Base).Elem()
+	local := reflect.ValueOf(c.Local).Elem()
+	remote := reflect.ValueOf(c.Remote).Elem()
+	if local.Type() != base.Type() || remote.Type() != base.Type() {
+		return nil, ErrUnresolved
+	}
+
+	// the merged item starts as the remote one, so the content it was parsed
+	// from is kept
+	merged := reflect.New(base.Type()).Elem()
+	merged.Set(remote)
+	for i := 0; i < base.NumField(); i++ {
+		if base.Type().Field(i).PkgPath != "" {
+			// unexported
+			continue
+		}
+
+		b, l, r := base.Field(i), local.Field(i), remote.Field(i)
+		switch {
+		case timestamps[base.Type().Field(i).Name]:
+			if l.Interface().(time.Time).After(r.Interface().(time.Time)) {
+				merged.Field(i).Set(l)
+			} else {
+				merged.Field(i).Set(r)
+			}
+		case equal(l, b):
+			merged.Field(i).Set(r)
+		case equal(r, b), equal(l, r):
+			merged.Field(i).Set(l)
+		default:
+			return nil, ErrUnresolved
+		}
+	}
+	return merged.Addr().Interface().(pim.Item), nil
+}
+
+// equal compares two property values, times are equal if they're the same
+// instant
+func equal(a, b reflect.Value) bool {
+	if t, ok := a.Interface().(time.Time); ok {
+		return t.Equal(b.Interface().(time.Time))
+	}
+	return reflect.DeepEqual(a.Interface(), b.Interface())
+}
+
+// sameItem reports whether two items, which may be nil, are the same
+func sameItem(a, b pim.Item) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a == b || a.Encode() == b.Encode()
+}
+
+// conflict is a Conflict found by a pull along with its entries
+type conflict struct {
+	*Conflict
+	// changes are the pending changes of the item
+	changes store.Changes
+	local   *api.Entry
+	remote  *api.Entry
+}
+
+// itemOf returns the item of an entry, nil if the entry deletes it
+func itemOf(cipher *crypto.Cipher, e *api.Entry) (pim.Item, *api.EntryContent, error) {
+	content, err := e.GetContent(cipher)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	item, err := pim.FromEntry(content)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if content.Action == api.ActionDelete {
+		return nil, content, nil
+	}
+	return item, content, nil
+}
+
+// entryItemUID returns the uid of the item changed by an entry, or an empty
+// string if it's not an item we track, as invalid entries
+func entryItemUID(cipher *crypto.Cipher, e *api.Entry) string {
+	content, err := e.GetContent(cipher)
+	if err != nil {
+		return ""
+	}
+
+	item, err := pim.FromEntry(content)
+	if err != nil {
+		return ""
+	}
+	return item.ItemUID()
+}
+
+// findConflicts returns the items changed by the pending changes of a journal
+// and by the pulled entries, it must be called before the entries are stored
+func (c *Cache) findConflicts(s store.Store, uid string, entries api.Entries) ([]*conflict, error) {
+	pending, err := s.PendingChanges(uid)
+	if err != nil || len(pending) == 0 || len(entries) == 0 {
+		return nil, err
+	}
+
+	cipher := crypto.New([]byte(uid), c.key)
+	remote := make(map[string]*api.Entry)
+	for _, e := range entries {
+		if itemUID := entryItemUID(cipher, e); itemUID != "" {
+			remote[itemUID] = e
+		}
+	}
+
+	var conflicts []*conflict
+	found := make(map[string]*conflict)
+	for _, p := range pending {
+		itemUID := entryItemUID(cipher, p.Entry)
+		if remote[itemUID] == nil {
+			continue
+		}
+
+		cf := found[itemUID]
+		if cf == nil {
+			cf = &conflict{
+				Conflict: &Conflict{JournalUID: uid, ItemUID: itemUID},
+				remote:   remote[itemUID],
+			}
+			found[itemUID] = cf
+			conflicts = append(conflicts, cf)
+		}
+		cf.changes = append(cf.changes, p)
+		cf.local = p.Entry
+	}
+
+	if len(conflicts) == 0 {
+		return nil, nil
+	}
+
+	// the base is the last stored entry of every item, if it wasn't compacted
+	stored, err := s.GetEntries(uid)
+	if err != nil {
+		return nil, err
+	}
+
+	base := make(map[string]*api.Entry)
+	for _, e := range stored {
+		if itemUID := entryItemUID(cipher, e); found[itemUID] != nil {
+			base[itemUID] = e
+		}
+	}
+
+	for _, cf := range conflicts {
+		if cf.Local, _, err = itemOf(cipher, cf.local); err != nil {
+			return nil, err
+		}
+		if cf.Remote, _, err = itemOf(cipher, cf.remote); err != nil {
+			return nil, err
+		}
+		if e := base[cf.ItemUID]; e != nil {
+			if cf.Base, _, err = itemOf(cipher, e); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return conflicts, nil
+}
+
+// resolve replaces the pending changes of the conflicting items with the
+// item kept by the resolver, unresolved conflicts are recorded keeping the
+// server item. It returns the conflicts resolved keeping the server item.
+func (c *Cache) resolve(s store.Store, uid string, conflicts []*conflict) ([]*Conflict, error) {
+	resolver := c.resolver
+	if resolver == nil {
+		resolver = ServerWins
+	}
+
+	var discarded []*Conflict
+	cipher := crypto.New([]byte(uid), c.key)
+	for _, cf := range conflicts {
+		item, err := resolver.Resolve(cf.Conflict)
+		if err != nil && err != ErrUnresolved {
+			return nil, err
+		}
+
+		for _, ch := range cf.changes {
+			if err := s.DiscardChange(ch.ID); err != nil {
+				return nil, err
+			}
+		}
+
+		if err == ErrUnresolved {
+			err := s.AddConflict(&store.Conflict{
+				JournalUID: uid,
+				ItemUID:    cf.ItemUID,
+				Local:      cf.local,
+				Remote:     cf.remote,
+				CreatedAt:  time.Now(),
+			})
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		if sameItem(item, cf.Remote) {
+			discarded = append(discarded, cf.Conflict)
+			continue
+		}
+
+		action := api.ActionChange
+		if cf.Remote == nil {
+			action = api.ActionAdd
+		}
+
+		// the kept side is queued as it was, only merged items are encoded
+		var content *api.EntryContent
+		switch {
+		case item == nil:
+			content, err = reuse(cipher, cf.remote, api.ActionDelete)
+		case item == cf.Local:
+			content, err = reuse(cipher, cf.local, action)
+		default:
+			content = pim.ToEntry(action, item)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if _, err := queue(s, uid, cipher, content); err != nil {
+			return nil, err
+		}
+	}
+	return discarded, nil
+}
+
+// reuse returns the content of an entry with another action
+func reuse(cipher *crypto.Cipher, e *api.Entry, action string) (*api.EntryContent, error) {
+	content, err := e.GetContent(cipher)
+	if err != nil {
+		return nil, err
+	}
+
+	content.Action = action
+	return content, nil
+}
+
+// Conflicts returns the conflicts the resolver couldn't resolve, for every
+// journal if uid is empty
+func (c *Cache) Conflicts(uid string) (store.Conflicts, error) {
+	return c.store.Conflicts(uid)
+}
+
+// ResolveConflict discards a recorded conflict keeping item, which is queued
+// as a local change. A nil item deletes it.
+func (c *Cache) ResolveConflict(conflict *store.Conflict, item pim.Item) error {
+	var err error
+	if item != nil {
+		err = c.Put(conflict.JournalUID, item)
+	} else {
+		err = c.Delete(conflict.JournalUID, conflict.ItemUID)
+	}
+	if err != nil && err != store.ErrRecordNotFound {
+		return err
+	}
+
+	return c.store.DeleteConflict(conflict.ID)
+}
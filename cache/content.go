@@ -0,0 +1,203 @@
+package cache
+
+import (
+	"container/list"
+	"sync"
+
+	"github.com/gchaincl/go-etesync/api"
+	"github.com/gchaincl/go-etesync/crypto"
+	"github.com/gchaincl/go-etesync/pim"
+)
+
+// DefaultContentCacheSize is the memory used by default to keep decrypted
+// content, see WithContentCache
+const DefaultContentCacheSize = 16 << 20
+
+// DecodedEntry is a decrypted entry, Item is nil if the content isn't an
+// event, task or contact
+type DecodedEntry struct {
+	Content *api.EntryContent
+	Item    pim.Item
+}
+
+// WithContentCache bounds the memory used to keep the content decrypted and
+// parsed by Decode and JournalContent to about maxBytes, the least recently
+// used content is evicted first. A maxBytes <= 0 disables it.
+func WithContentCache(maxBytes int) Option {
+	return func(c *Cache) { c.contents = newLRU(maxBytes) }
+}
+
+// Decode returns the decrypted and parsed content of an entry of a journal,
+// which is kept in memory as entries never change. The caller gets a copy it
+// can modify.
+func (c *Cache) Decode(journalUID string, e *api.Entry) (*DecodedEntry, error) {
+	key := lruKey{journalUID: journalUID, uid: e.UID}
+	if v, ok := c.contents.get(key); ok {
+		return v.(*DecodedEntry).copy(), nil
+	}
+
+	content, err := e.GetContent(crypto.New([]byte(journalUID), c.key))
+	if err != nil {
+		return nil, err
+	}
+
+	item, err := pim.FromEntry(content)
+	if err != nil && err != pim.ErrUnknownComponent {
+		return nil, err
+	}
+
+	d := &DecodedEntry{Content: content, Item: item}
+	// the parsed item takes about as much as its encoded content
+	c.contents.add(key, d, len(e.UID)+2*len(content.Content))
+	return d.copy(), nil
+}
+
+func (d *DecodedEntry) copy() *DecodedEntry {
+	content := *d.Content
+	return &DecodedEntry{Content: &content, Item: copyItem(d.Item)}
+}
+
+// copyItem returns a copy of an item not sharing its slices
+func copyItem(item pim.Item) pim.Item {
+	switch v := item.(type) {
+	case *pim.Event:
+		cp := *v
+		cp.Categories = append([]string(nil), v.Categories...)
+		return &cp
+	case *pim.Task:
+		cp := *v
+		cp.Categories = append([]string(nil), v.Categories...)
+		return &cp
+	case *pim.Contact:
+		cp := *v
+		cp.Emails = append([]pim.Email(nil), v.Emails...)
+		cp.Phones = append([]pim.Phone(nil), v.Phones...)
+		cp.Addresses = append([]pim.Address(nil), v.Addresses...)
+		cp.Categories = append([]string(nil), v.Categories...)
+		return &cp
+	}
+	return item
+}
+
+// JournalContent returns the decrypted content of a journal, which is kept
+// in memory until the journal is synced again. The caller gets a copy it can
+// modify.
+func (c *Cache) JournalContent(j *api.Journal) (*api.JournalContent, error) {
+	key := lruKey{journalUID: j.UID}
+	if v, ok := c.contents.get(key); ok {
+		content := *v.(*api.JournalContent)
+		return &content, nil
+	}
+
+	content, err := j.GetContent(crypto.New([]byte(j.UID), c.key))
+	if err != nil {
+		return nil, err
+	}
+
+	c.contents.add(key, content, len(j.UID)+len(j.Content))
+	cp := *content
+	return &cp, nil
+}
+
+// lruKey identifies an entry of a journal, or the journal itself when uid is
+// empty
+type lruKey struct {
+	journalUID string
+	uid        string
+}
+
+type lruValue struct {
+	key   lruKey
+	value interface{}
+	size  int
+}
+
+// lru is a least recently used cache bounded by the size of its values. A
+// nil lru caches nothing.
+type lru struct {
+	mu     sync.Mutex
+	max    int
+	size   int
+	order  *list.List
+	values map[lruKey]*list.Element
+}
+
+func newLRU(max int) *lru {
+	if max <= 0 {
+		return nil
+	}
+	return &lru{max: max, order: list.New(), values: make(map[lruKey]*list.Element)}
+}
+
+func (l *lru) get(key lruKey) (interface{}, bool) {
+	if l == nil {
+		return nil, false
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	el, ok := l.values[key]
+	if !ok {
+		return nil, false
+	}
+	l.order.MoveToFront(el)
+	return el.Value.(*lruValue).value, true
+}
+
+// add adds a value evicting the least recently used ones to make room, values
+// bigger than the cache are not added
+func (l *lru) add(key lruKey, value interface{}, size int) {
+	if l == nil || size > l.max {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if el, ok := l.values[key]; ok {
+		l.removeElement(el)
+	}
+
+	l.values[key] = l.order.PushFront(&lruValue{key: key, value: value, size: size})
+	l.size += size
+	for l.size > l.max {
+		l.removeElement(l.order.Back())
+	}
+}
+
+// remove removes the value of key, if any
+func (l *lru) remove(key lruKey) {
+	if l == nil {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if el, ok := l.values[key]; ok {
+		l.removeElement(el)
+	}
+}
+
+// removeJournal removes the values of a journal and its entries
+func (l *lru) removeJournal(journalUID string) {
+	if l == nil {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for key, el := range l.values {
+		if key.journalUID == journalUID {
+			l.removeElement(el)
+		}
+	}
+}
+
+func (l *lru) removeElement(el *list.Element) {
+	v := l.order.Remove(el).(*lruValue)
+	delete(l.values, v.key)
+	l.size -= v.size
+}
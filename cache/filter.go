@@ -0,0 +1,97 @@
+package cache
+
+import (
+	"strings"
+
+	"github.com/gchaincl/go-etesync/api"
+	"github.com/gchaincl/go-etesync/crypto"
+)
+
+// SyncFilter selects the journals synced by Sync, the zero value selects
+// every journal. A journal must match every set field to be synced.
+type SyncFilter struct {
+	// Types restricts the sync to journals of these types
+	Types []api.JournalType
+	// OnlySelected skips the journals not marked as selected
+	OnlySelected bool
+	// Allow restricts the sync to journals whose UID or display name is
+	// listed, names are compared case insensitively
+	Allow []string
+	// Deny skips the journals whose UID or display name is listed, it takes
+	// precedence over Allow
+	Deny []string
+}
+
+// WithSyncFilter sets the journals synced by Sync. The journals skipped are
+// neither downloaded nor archived, see SyncReport.Skipped, and their pending
+// changes aren't pushed until they're synced again.
+func WithSyncFilter(f SyncFilter) Option {
+	return func(c *Cache) { c.filter = f }
+}
+
+// empty reports whether the filter selects every journal
+func (f *SyncFilter) empty() bool {
+	return len(f.Types) == 0 && !f.OnlySelected && len(f.Allow) == 0 && len(f.Deny) == 0
+}
+
+// Match reports whether the filter selects a journal given its decrypted
+// content
+func (f *SyncFilter) Match(uid string, content *api.JournalContent) bool {
+	if len(f.Types) > 0 {
+		var found bool
+		for _, t := range f.Types {
+			if t == content.Type {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	if f.OnlySelected && !content.Selected {
+		return false
+	}
+
+	if listed(f.Deny, uid, content.DisplayName) {
+		return false
+	}
+
+	return len(f.Allow) == 0 || listed(f.Allow, uid, content.DisplayName)
+}
+
+// listed reports whether list contains the uid or the name of a journal
+func listed(list []string, uid, name string) bool {
+	for _, s := range list {
+		if s == uid || strings.EqualFold(s, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// filterJournals splits the journals into the ones selected by the filter
+// and the skipped ones
+func (c *Cache) filterJournals(js api.Journals) (api.Journals, api.Journals, SyncError) {
+	if c.filter.empty() {
+		return js, nil, nil
+	}
+
+	var selected, skipped api.Journals
+	var failed SyncError
+	for _, j := range js {
+		content, err := j.GetContent(crypto.New([]byte(j.UID), c.key))
+		if err != nil {
+			failed = append(failed, &JournalError{JournalUID: j.UID, Err: err})
+			continue
+		}
+
+		if c.filter.Match(j.UID, content) {
+			selected = append(selected, j)
+		} else {
+			skipped = append(skipped, j)
+		}
+	}
+	return selected, skipped, failed
+}
@@ -0,0 +1,241 @@
+package cache
+
+import (
+	"errors"
+	"time"
+
+	"github.com/gchaincl/go-etesync/api"
+	"github.com/gchaincl/go-etesync/crypto"
+	"github.com/gchaincl/go-etesync/pim"
+	"github.com/gchaincl/go-etesync/store"
+)
+
+var (
+	// ErrReadOnly is returned when changing an item of a read-only journal
+	ErrReadOnly = errors.New("journal is read-only")
+)
+
+// maxPushRetries is how many times a push is retried after a conflict
+const maxPushRetries = 3
+
+// Put adds or changes an item of a journal. The item is updated right away
+// while the change is queued until the next Sync pushes it to the server.
+// An item parsed from an entry keeps the properties it doesn't model, only
+// the modified ones are changed.
+func (c *Cache) Put(journalUID string, item pim.Item) error {
+	action := api.ActionAdd
+	if _, err := c.store.Item(journalUID, item.ItemUID()); err == nil {
+		action = api.ActionChange
+	} else if err != store.ErrRecordNotFound {
+		return err
+	}
+
+	return c.enqueue(journalUID, pim.ToEntry(action, item))
+}
+
+// Delete deletes an item of a journal, as Put the change is queued until the
+// next Sync
+func (c *Cache) Delete(journalUID, itemUID string) error {
+	i, err := c.store.Item(journalUID, itemUID)
+	if err != nil {
+		return err
+	}
+
+	content, err := i.Entry.GetContent(crypto.New([]byte(journalUID), c.key))
+	if err != nil {
+		return err
+	}
+
+	item, err := pim.FromEntry(content)
+	if err != nil {
+		return err
+	}
+
+	return c.enqueue(journalUID, pim.ToEntry(api.ActionDelete, item))
+}
+
+// enqueue queues a local entry and applies it to the current items
+func (c *Cache) enqueue(uid string, content *api.EntryContent) error {
+	j, err := c.store.GetJournal(uid)
+	if err != nil {
+		return err
+	}
+
+	if j.ReadOnly {
+		return ErrReadOnly
+	}
+
+	cipher := crypto.New([]byte(uid), c.key)
+	var ch *change
+	err = c.store.WithTx(func(s store.Store) error {
+		e, err := queue(s, uid, cipher, content)
+		if err != nil {
+			return err
+		}
+
+		ch, err = apply(s, uid, cipher, e)
+		return err
+	})
+	if err != nil || ch == nil {
+		return err
+	}
+
+	return c.indexChanges(uid, []*change{ch})
+}
+
+// queue encrypts a local entry and adds it to the outbox
+func queue(s store.Store, uid string, cipher *crypto.Cipher, content *api.EntryContent) (*api.Entry, error) {
+	e := &api.Entry{}
+	if err := e.SetContent(content, cipher); err != nil {
+		return nil, err
+	}
+
+	prev, err := nextUID(s, uid)
+	if err != nil {
+		return nil, err
+	}
+
+	// the UID is set again when pushed, in case other entries are pulled
+	if err := e.SetUID(prev, cipher); err != nil {
+		return nil, err
+	}
+
+	change := &store.Change{JournalUID: uid, Entry: e, CreatedAt: time.Now()}
+	if err := s.EnqueueChange(change); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// nextUID returns the UID a new local entry follows, which is the last
+// pending change or the last stored entry
+func nextUID(s store.Store, uid string) (string, error) {
+	pending, err := s.PendingChanges(uid)
+	if err != nil {
+		return "", err
+	}
+
+	if len(pending) > 0 {
+		return pending[len(pending)-1].Entry.UID, nil
+	}
+	return lastEntryUID(s, uid)
+}
+
+// reconcile marks as sent the pending changes found among the pulled entries,
+// which were pushed by a sync that failed before recording it
+func reconcile(s store.Store, uid string, entries api.Entries) error {
+	pending, err := s.PendingChanges(uid)
+	if err != nil || len(pending) == 0 {
+		return err
+	}
+
+	// pushed entries keep the content of their change, which is unique as
+	// it's encrypted with a random IV
+	pulled := make(map[string]bool, len(entries))
+	for _, e := range entries {
+		pulled[e.Content] = true
+	}
+
+	for _, p := range pending {
+		if !pulled[p.Entry.Content] {
+			continue
+		}
+		if err := s.MarkChangeSent(p.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rebase applies the pending changes of a journal on top of its items, so
+// local changes are kept after pulling entries from the server
+func (c *Cache) rebase(s store.Store, uid string) ([]*change, error) {
+	pending, err := s.PendingChanges(uid)
+	if err != nil {
+		return nil, err
+	}
+
+	var changes []*change
+	cipher := crypto.New([]byte(uid), c.key)
+	for _, p := range pending {
+		ch, err := apply(s, uid, cipher, p.Entry)
+		if err != nil {
+			return nil, err
+		}
+
+		if ch != nil {
+			changes = append(changes, ch)
+		}
+	}
+	return changes, nil
+}
+
+// push sends the pending changes of a journal following its last stored
+// entry. On a conflict the journal is pulled before retrying.
+// It returns the number of pushed entries and the conflicts discarded by the
+// resolver when pulling.
+func (c *Cache) push(uid string) (int, []*Conflict, error) {
+	var discarded []*Conflict
+	cipher := crypto.New([]byte(uid), c.key)
+	for attempt := 0; ; attempt++ {
+		pending, err := c.store.PendingChanges(uid)
+		if err != nil || len(pending) == 0 {
+			return 0, discarded, err
+		}
+
+		var last *string
+		e, err := c.store.LastEntry(uid)
+		if err == nil {
+			last = &e.UID
+		} else if err != store.ErrRecordNotFound {
+			return 0, discarded, err
+		}
+
+		// chain the entries after the last one
+		entries := make(api.Entries, len(pending))
+		prev := ""
+		if last != nil {
+			prev = *last
+		}
+		for i, p := range pending {
+			entries[i] = &api.Entry{Content: p.Entry.Content}
+			if err := entries[i].SetUID(prev, cipher); err != nil {
+				return 0, discarded, err
+			}
+			prev = entries[i].UID
+		}
+
+		err = c.api.CreateEntries(uid, last, entries)
+		if err == api.ErrConflict && attempt < maxPushRetries {
+			p, err := c.pull(uid, nil)
+			discarded = append(discarded, p.discarded...)
+			if err != nil {
+				return 0, discarded, err
+			}
+			continue
+		}
+		if err != nil {
+			return 0, discarded, err
+		}
+
+		var changes []*change
+		err = c.store.WithTx(func(s store.Store) error {
+			var err error
+			if changes, _, err = c.write(s, uid, entries); err != nil {
+				return err
+			}
+
+			for _, p := range pending {
+				if err := s.MarkChangeSent(p.ID); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return 0, discarded, err
+		}
+
+		return len(entries), discarded, c.indexChanges(uid, changes)
+	}
+}
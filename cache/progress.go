@@ -0,0 +1,95 @@
+package cache
+
+// ProgressKind is what a ProgressEvent reports
+type ProgressKind int
+
+const (
+	// JournalStarted is sent before a journal is synced
+	JournalStarted ProgressKind = iota
+	// EntriesFetched is sent when new entries are pulled from the server
+	EntriesFetched
+	// EntriesPushed is sent when pending changes are pushed to the server
+	EntriesPushed
+	// SyncFailed is sent when syncing a journal, or listing them, fails
+	SyncFailed
+	// JournalFinished is sent after a journal is synced, successfully or not
+	JournalFinished
+)
+
+func (k ProgressKind) String() string {
+	switch k {
+	case JournalStarted:
+		return "started"
+	case EntriesFetched:
+		return "fetched"
+	case EntriesPushed:
+		return "pushed"
+	case SyncFailed:
+		return "failed"
+	case JournalFinished:
+		return "finished"
+	}
+	return "unknown"
+}
+
+// ProgressEvent reports the progress of a sync
+type ProgressEvent struct {
+	Kind ProgressKind
+	// JournalUID is empty if listing the journals failed
+	JournalUID string
+	// Journal is the position of the journal, starting at 1, among the
+	// Journals being synced
+	Journal  int
+	Journals int
+	// Entries is the number of fetched or pushed entries
+	Entries int
+	// Err is set on SyncFailed and on JournalFinished if it failed
+	Err error
+}
+
+// Observer receives the progress of every sync, it's called synchronously
+// so it should return quickly. It's called concurrently when the Cache syncs
+// several journals at once, see WithConcurrency.
+type Observer func(ProgressEvent)
+
+// observer wraps an Observer so it can be found to be removed
+type observer struct {
+	fn Observer
+}
+
+// WithObserver adds an observer of every sync
+func WithObserver(fn Observer) Option {
+	return func(c *Cache) { c.Observe(fn) }
+}
+
+// Observe adds an observer of every sync, until the returned function is
+// called
+func (c *Cache) Observe(fn Observer) (stop func()) {
+	o := &observer{fn: fn}
+
+	c.mu.Lock()
+	c.observers = append(c.observers, o)
+	c.mu.Unlock()
+
+	return func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		for i, found := range c.observers {
+			if found == o {
+				c.observers = append(c.observers[:i:i], c.observers[i+1:]...)
+				return
+			}
+		}
+	}
+}
+
+// notify sends an event to the observers
+func (c *Cache) notify(ev ProgressEvent) {
+	c.mu.Lock()
+	observers := c.observers
+	c.mu.Unlock()
+
+	for _, o := range observers {
+		o.fn(ev)
+	}
+}
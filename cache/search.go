@@ -0,0 +1,139 @@
+package cache
+
+import (
+	"errors"
+	"strings"
+	"unicode"
+
+	"github.com/gchaincl/go-etesync/crypto"
+	"github.com/gchaincl/go-etesync/pim"
+	"github.com/gchaincl/go-etesync/store"
+)
+
+var (
+	// ErrNoIndex is returned when searching a Cache created without WithIndex
+	ErrNoIndex = errors.New("search index not enabled")
+)
+
+// Item kinds of the indexed documents
+const (
+	KindEvent   = "event"
+	KindTask    = "task"
+	KindContact = "contact"
+)
+
+// Search returns the items matching every term of query
+func (c *Cache) Search(query string, filter store.SearchFilter) ([]*store.Document, error) {
+	if c.index == nil {
+		return nil, ErrNoIndex
+	}
+	return c.index.Search(query, filter)
+}
+
+// Reindex indexes every current item, use it to populate an index enabled on
+// a cache that has already been synced
+func (c *Cache) Reindex() error {
+	if c.index == nil {
+		return ErrNoIndex
+	}
+
+	js, err := c.store.GetJournals()
+	if err != nil {
+		return err
+	}
+
+	for _, j := range js {
+		items, err := c.store.Items(j.UID)
+		if err != nil {
+			return err
+		}
+
+		cipher := crypto.New([]byte(j.UID), c.key)
+		for _, i := range items {
+			content, err := i.Entry.GetContent(cipher)
+			if err != nil {
+				return err
+			}
+
+			item, err := pim.FromEntry(content)
+			if err != nil {
+				return err
+			}
+
+			if err := c.index.IndexDocument(document(j.UID, item)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// indexChanges updates the index, if enabled, with the changes of a sync
+func (c *Cache) indexChanges(uid string, changes []*change) error {
+	if c.index == nil {
+		return nil
+	}
+
+	for _, ch := range changes {
+		var err error
+		if ch.item == nil {
+			err = c.index.RemoveDocument(uid, ch.uid)
+		} else {
+			err = c.index.IndexDocument(document(uid, ch.item))
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// document returns the searchable text of an item
+func document(journalUID string, item pim.Item) *store.Document {
+	doc := &store.Document{JournalUID: journalUID, ItemUID: item.ItemUID()}
+
+	var text []string
+	switch item := item.(type) {
+	case *pim.Event:
+		doc.Kind = KindEvent
+		text = append(text, item.Summary, item.Description, item.Location)
+		text = append(text, item.Categories...)
+	case *pim.Task:
+		doc.Kind = KindTask
+		text = append(text, item.Summary, item.Description, item.Status)
+		text = append(text, item.Categories...)
+	case *pim.Contact:
+		doc.Kind = KindContact
+		n := item.Name
+		text = append(text, item.FormattedName, n.Given, n.Additional, n.Family, item.Organization, item.Note)
+		for _, e := range item.Emails {
+			text = append(text, e.Address)
+		}
+		for _, p := range item.Phones {
+			// digits only, so numbers match regardless of their format
+			text = append(text, p.Number, strings.Map(digits, p.Number))
+		}
+		for _, a := range item.Addresses {
+			text = append(text, a.Street, a.Locality, a.Region, a.PostalCode, a.Country)
+		}
+		text = append(text, item.Categories...)
+	}
+
+	var nonEmpty []string
+	for _, t := range text {
+		if t != "" {
+			nonEmpty = append(nonEmpty, t)
+		}
+	}
+	doc.Text = strings.Join(nonEmpty, "\n")
+
+	return doc
+}
+
+func digits(r rune) rune {
+	if unicode.IsDigit(r) {
+		return r
+	}
+	return -1
+}
@@ -0,0 +1,193 @@
+package cache
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/gchaincl/go-etesync/api"
+	"github.com/gchaincl/go-etesync/store"
+)
+
+// Verification is the outcome of comparing the stored entries of a journal
+// with the ones on the server
+type Verification struct {
+	JournalUID string
+	// Missing are the entries on the server which aren't stored
+	Missing []string
+	// Extra are the stored entries which aren't on the server
+	Extra []string
+	// Diverging are the entries stored with a different content
+	Diverging []string
+	// Compacted is the number of entries deleted from the history by
+	// Compact, which aren't reported as missing as they're expected
+	Compacted int
+}
+
+// OK reports whether the stored entries match the server
+func (v *Verification) OK() bool {
+	return len(v.Missing) == 0 && len(v.Extra) == 0 && len(v.Diverging) == 0
+}
+
+func (v *Verification) String() string {
+	if v.OK() {
+		return "ok"
+	}
+	return fmt.Sprintf("%d missing, %d extra and %d diverging entries",
+		len(v.Missing), len(v.Extra), len(v.Diverging))
+}
+
+// Verify compares the stored entries of a journal with the server's
+func (c *Cache) Verify(uid string) (*Verification, error) {
+	c.syncing.Lock()
+	defer c.syncing.Unlock()
+
+	if c.api == nil {
+		return nil, ErrOffline
+	}
+
+	remote, err := c.api.JournalEntries(uid, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	local, err := c.store.GetEntries(uid)
+	if err != nil {
+		return nil, err
+	}
+
+	var compacted string
+	state, err := c.store.SyncState(uid)
+	if err == nil {
+		compacted = state.CompactedBefore
+	} else if err != store.ErrRecordNotFound {
+		return nil, err
+	}
+
+	return verify(uid, local, remote, compacted), nil
+}
+
+// verify compares the entries, the remote ones before compacted were deleted
+// by Compact
+func verify(uid string, local, remote api.Entries, compacted string) *Verification {
+	v := &Verification{JournalUID: uid}
+
+	stored := make(map[string]*api.Entry, len(local))
+	for _, e := range local {
+		stored[e.UID] = e
+	}
+
+	first := 0
+	positions := make(map[string]int, len(remote))
+	for i, e := range remote {
+		positions[e.UID] = i
+		if compacted != "" && e.UID == compacted {
+			first = i
+		}
+	}
+
+	for i, e := range remote {
+		s := stored[e.UID]
+		switch {
+		case s == nil && i < first:
+			v.Compacted++
+		case s == nil:
+			v.Missing = append(v.Missing, e.UID)
+		case s.Content != e.Content:
+			v.Diverging = append(v.Diverging, e.UID)
+		}
+	}
+
+	for _, e := range local {
+		if _, ok := positions[e.UID]; !ok {
+			v.Extra = append(v.Extra, e.UID)
+		}
+	}
+	return v
+}
+
+// Repair deletes the stored entries and items of a journal and downloads
+// them again within a transaction. Pending changes and conflicts are kept.
+func (c *Cache) Repair(uid string) error {
+	c.syncing.Lock()
+	defer c.syncing.Unlock()
+
+	if c.api == nil {
+		return ErrOffline
+	}
+
+	j, err := c.api.Journal(uid)
+	if err != nil {
+		return err
+	}
+	if j == nil {
+		return store.ErrRecordNotFound
+	}
+
+	start := time.Now()
+	entries, err := c.api.JournalEntries(uid, nil)
+	if err != nil {
+		return err
+	}
+
+	old, err := c.store.Items(uid)
+	if err != nil {
+		return err
+	}
+
+	var changes []*change
+	var invalid int
+	err = c.store.WithTx(func(s store.Store) error {
+		pending, err := s.PendingChanges(uid)
+		if err != nil {
+			return err
+		}
+
+		conflicts, err := s.Conflicts(uid)
+		if err != nil {
+			return err
+		}
+
+		if err := s.PurgeJournal(uid); err != nil {
+			return err
+		}
+
+		for _, ch := range pending {
+			if err := s.EnqueueChange(ch); err != nil {
+				return err
+			}
+		}
+		for _, cf := range conflicts {
+			if err := s.AddConflict(cf); err != nil {
+				return err
+			}
+		}
+
+		if err := s.CreateJournal(j); err != nil {
+			return err
+		}
+
+		if changes, invalid, err = c.write(s, uid, entries); err != nil {
+			return err
+		}
+
+		rebased, err := c.rebase(s, uid)
+		changes = append(changes, rebased...)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	// repaired entries may have the uid of different ones
+	c.contents.removeJournal(uid)
+
+	removed := make([]*change, len(old))
+	for i, item := range old {
+		removed[i] = &change{uid: item.UID}
+	}
+	if err := c.indexChanges(uid, append(removed, changes...)); err != nil {
+		return err
+	}
+
+	return c.saveSyncState(uid, start, len(entries), invalid, nil)
+}
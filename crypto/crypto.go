@@ -78,6 +78,11 @@ func (c *Cipher) Decrypt(data []byte) ([]byte, error) {
 	return padding.NewPkcs7Padding(blockSize).Unpad(plaintext)
 }
 
+// HMAC returns the HMAC-SHA256 of data using the cipher's hmac key
+func (c *Cipher) HMAC(data []byte) []byte {
+	return hmac256(c.hmacKey, data)
+}
+
 // DeriveKey derives a password using scrypt
 func DeriveKey(password, salt []byte) ([]byte, error) {
 	return scrypt.Key(password, salt, 16384, 8, 1, 190)
@@ -18,3 +18,12 @@ func TestCipher(t *testing.T) {
 
 	assert.Equal(t, plaintext, dec)
 }
+
+func TestHMAC(t *testing.T) {
+	m := New([]byte("salt"), []byte("key"))
+	data := []byte("some data")
+
+	assert.Equal(t, m.HMAC(data), m.HMAC(data))
+	assert.Len(t, m.HMAC(data), 32)
+	assert.NotEqual(t, m.HMAC(data), New([]byte("other"), []byte("key")).HMAC(data))
+}
@@ -1,15 +1,17 @@
 package gui
 
 import (
+	"fmt"
 	"log"
+	"sync"
 	"time"
 
 	"github.com/gchaincl/go-etesync/api"
 	"github.com/gchaincl/go-etesync/cache"
-	"github.com/gchaincl/go-etesync/crypto"
+	"github.com/gchaincl/go-etesync/pim"
+	"github.com/gchaincl/go-etesync/store"
 	"github.com/gdamore/tcell"
 	"github.com/kofoworola/godate"
-	"github.com/laurent22/ical-go"
 	"github.com/rivo/tview"
 )
 
@@ -20,14 +22,12 @@ type GUI struct {
 	journals *tview.Table
 
 	cache *cache.Cache
-	key   []byte
 }
 
-func New(cache *cache.Cache, key []byte) *GUI {
+func New(c *cache.Cache) *GUI {
 	gui := &GUI{
 		app:   tview.NewApplication(),
-		cache: cache,
-		key:   key,
+		cache: c,
 	}
 
 	gui.page = tview.NewPages()
@@ -61,16 +61,39 @@ func New(cache *cache.Cache, key []byte) *GUI {
 						gui.page.RemovePage("sync")
 					})
 				gui.page.AddAndSwitchToPage("sync", modal, true)
+				// the modal is only changed within the UI goroutine
 				go func() {
-					defer gui.app.Draw()
-					if err := gui.cache.Sync(); err != nil {
+					var mu sync.Mutex
+					var done int
+					stop := gui.cache.Observe(func(ev cache.ProgressEvent) {
+						mu.Lock()
+						defer mu.Unlock()
+						if ev.Kind == cache.JournalFinished {
+							done++
+						}
+						txt := progressText(ev, done)
+						gui.app.QueueUpdateDraw(func() { modal.SetText(txt) })
+					})
+					report, err := gui.cache.Sync()
+					stop()
+					if err != nil {
 						txt += ": " + err.Error()
 					} else {
 						txt += ": ok"
 					}
-					modal.SetText(txt).AddButtons([]string{"OK"})
-					gui.app.SetFocus(modal)
-					_ = gui.draw()
+					if n := len(report.Removed); n > 0 {
+						txt += fmt.Sprintf(" (%d removed from the server)", n)
+					}
+					if n := len(report.Discarded); n > 0 {
+						txt += fmt.Sprintf("\n%d conflicting local changes discarded", n)
+					}
+					gui.app.QueueUpdateDraw(func() {
+						modal.SetText(txt).AddButtons([]string{"OK"})
+						gui.app.SetFocus(modal)
+						if err := gui.refresh(); err != nil {
+							log.Println(err)
+						}
+					})
 				}()
 			}
 		}
@@ -97,20 +120,38 @@ func (gui *GUI) newEntries() *tview.Table {
 }
 
 func (gui *GUI) newJournals() (*tview.Table, error) {
+	t := tview.NewTable().SetSelectable(true, false)
+	t.SetTitle("Journals").SetBorder(true)
+
+	if err := gui.fillJournals(t); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// fillJournals sets the rows of the journals table
+func (gui *GUI) fillJournals(t *tview.Table) error {
 	js, err := gui.cache.Journals()
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	t := tview.NewTable().SetSelectable(true, false)
-	t.SetTitle("Journals").SetBorder(true)
+	states, err := gui.cache.SyncStates()
+	if err != nil {
+		return err
+	}
 
+	synced := make(map[string]*store.SyncState, len(states))
+	for _, st := range states {
+		synced[st.JournalUID] = st
+	}
+
+	t.Clear()
 	uids := make([]*api.Journal, len(js))
 	for i, j := range js {
-		cipher := crypto.New([]byte(j.UID), gui.key)
-		content, err := j.GetContent(cipher)
+		content, err := gui.cache.JournalContent(j)
 		if err != nil {
-			return nil, err
+			return err
 		}
 		uids[i] = j
 
@@ -125,6 +166,9 @@ func (gui *GUI) newJournals() (*tview.Table, error) {
 			icon = "🗒"
 		}
 		t.SetCell(i, 0, tview.NewTableCell(icon+" "+content.DisplayName))
+		if st, ok := synced[j.UID]; ok {
+			t.SetCell(i, 1, syncCell(st))
+		}
 	}
 
 	t.SetSelectedFunc(func(row, col int) {
@@ -135,7 +179,7 @@ func (gui *GUI) newJournals() (*tview.Table, error) {
 		}
 	})
 
-	return t, nil
+	return nil
 }
 
 func setTableHeaders(t *tview.Table, headers ...string) {
@@ -148,13 +192,12 @@ func setTableHeaders(t *tview.Table, headers ...string) {
 }
 
 func (gui *GUI) onJournalSelect(j *api.Journal) error {
-	es, err := gui.cache.JournalEntries(j.UID)
+	items, err := gui.cache.Items(j.UID)
 	if err != nil {
 		return err
 	}
 
-	cipher := crypto.New([]byte(j.UID), gui.key)
-	jc, err := j.GetContent(cipher)
+	jc, err := gui.cache.JournalContent(j)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -162,61 +205,64 @@ func (gui *GUI) onJournalSelect(j *api.Journal) error {
 	gui.app.SetFocus(gui.entries)
 
 	gui.entries.Clear()
-	for i := 0; i < len(es); i++ {
-		// as entries are sorted from older to newer we get them from newer to older
-		e := es[len(es)-i-1]
+	for i := 0; i < len(items); i++ {
+		// as items are sorted from older to newer we get them from newer to older
+		e := items[len(items)-i-1].Entry
 
-		content, err := e.GetContent(cipher)
-		if err != nil {
-			return err
-		}
-
-		node, err := ical.ParseCalendar(content.Content)
+		decoded, err := gui.cache.Decode(j.UID, e)
 		if err != nil {
 			return err
 		}
+		content, item := decoded.Content, decoded.Item
 
 		var icon string
 		switch content.Action {
-		case "ADD":
+		case api.ActionAdd:
 			icon = "✔"
-		case "DELETE":
+		case api.ActionDelete:
 			icon = "✖"
-		case "CHANGE":
+		case api.ActionChange:
 			icon = "↪"
 		default:
 			icon = content.Action
 		}
-		switch node.Name {
-		case "VCARD":
+		switch item := item.(type) {
+		case *pim.Contact:
 			// set headers
 			if i == 0 {
 				setTableHeaders(gui.entries, "", "Name", "Phone")
 			}
 
+			name := item.FormattedName
+			if name == "" {
+				name = "<N/A>"
+			}
+			var phone string
+			if len(item.Phones) > 0 {
+				phone = item.Phones[0].Number
+			}
+
 			gui.entries.SetCellSimple(i+1, 0, icon)
-			gui.entries.SetCellSimple(i+1, 1, node.PropString("FN", "<N/A>"))
-			gui.entries.SetCellSimple(i+1, 2, node.PropString("TEL", ""))
-		case "VCALENDAR", "VTODO":
+			gui.entries.SetCellSimple(i+1, 1, name)
+			gui.entries.SetCellSimple(i+1, 2, phone)
+		case *pim.Event:
 			// set headers
 			if i == 0 {
 				setTableHeaders(gui.entries, "", "Summary", "Date")
 			}
 
-			child := node.ChildByName("VTODO")
-			if child == nil {
-				child = node.ChildByName("VEVENT")
+			gui.entries.SetCellSimple(i+1, 0, icon)
+			gui.entries.SetCellSimple(i+1, 1, item.Summary)
+			gui.entries.SetCellSimple(i+1, 2, humanize(item.Start))
+		case *pim.Task:
+			// set headers
+			if i == 0 {
+				setTableHeaders(gui.entries, "", "Summary", "Due")
 			}
 
-			if child != nil {
-				gui.entries.SetCellSimple(i+1, 0, icon)
-				gui.entries.SetCellSimple(i+1, 1, child.PropString("SUMMARY", ""))
-				when := child.PropDate("DTSTAMP", time.Time{})
-				diff := godate.Create(when).DifferenceFromNowForHumans()
-				gui.entries.SetCellSimple(i+1, 2, diff)
-			}
-		default:
-			panic(node.Name)
+			gui.entries.SetCellSimple(i+1, 0, icon)
+			gui.entries.SetCellSimple(i+1, 1, item.Summary)
+			gui.entries.SetCellSimple(i+1, 2, humanize(item.Due))
 		}
 
 		gui.entries.Select(1, 0)
@@ -225,6 +271,34 @@ func (gui *GUI) onJournalSelect(j *api.Journal) error {
 	return nil
 }
 
+// progressText describes the progress of a sync which finished done journals
+func progressText(ev cache.ProgressEvent, done int) string {
+	txt := fmt.Sprintf("syncing journals (%d/%d)", done, ev.Journals)
+	switch ev.Kind {
+	case cache.EntriesFetched, cache.EntriesPushed:
+		txt += fmt.Sprintf("\n%d entries %s", ev.Entries, ev.Kind)
+	case cache.SyncFailed:
+		txt += "\n" + ev.Err.Error()
+	}
+	return txt
+}
+
+// syncCell describes the last sync of a journal
+func syncCell(st *store.SyncState) *tview.TableCell {
+	if st.Error != "" {
+		return tview.NewTableCell("✖ " + st.Error).SetTextColor(tcell.ColorRed)
+	}
+	return tview.NewTableCell(humanize(st.LastSuccess)).SetTextColor(tcell.ColorGray)
+}
+
+// humanize returns t relative to now, or an empty string if t is zero
+func humanize(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return godate.Create(t).DifferenceFromNowForHumans()
+}
+
 func (gui *GUI) draw() error {
 	gui.entries = gui.newEntries()
 
@@ -242,9 +316,29 @@ func (gui *GUI) draw() error {
 	return nil
 }
 
+// refresh updates the journals table after a sync, keeping the current page
+// so a modal stays open. It must run within the UI goroutine.
+func (gui *GUI) refresh() error {
+	return gui.fillJournals(gui.journals)
+}
+
 func (gui *GUI) Start() error {
 	if err := gui.draw(); err != nil {
 		return err
 	}
+
+	// refresh the journals when a background sync changes them
+	changes, cancel := gui.cache.Subscribe(1)
+	defer cancel()
+	go func() {
+		for range changes {
+			gui.app.QueueUpdateDraw(func() {
+				if err := gui.refresh(); err != nil {
+					log.Println(err)
+				}
+			})
+		}
+	}()
+
 	return gui.app.SetRoot(gui.page, true).Run()
 }
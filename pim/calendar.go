@@ -0,0 +1,181 @@
+package pim
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/laurent22/ical-go"
+)
+
+const prodID = "-//go-etesync//pim//EN"
+
+// Event is a VEVENT
+type Event struct {
+	UID          string
+	Summary      string
+	Description  string
+	Location     string
+	Status       string
+	Start        time.Time
+	End          time.Time
+	AllDay       bool
+	Categories   []string
+	Created      time.Time
+	LastModified time.Time
+	Stamp        time.Time
+
+	// raw is the content the event was parsed from, empty for new events
+	raw string
+}
+
+var _ Item = &Event{}
+
+func parseEvent(node *ical.Node) *Event {
+	ev := &Event{
+		UID:         prop(node, "UID"),
+		Summary:     prop(node, "SUMMARY"),
+		Description: prop(node, "DESCRIPTION"),
+		Location:    prop(node, "LOCATION"),
+		Status:      prop(node, "STATUS"),
+		Categories:  categories(node),
+	}
+
+	ev.Start, ev.AllDay = propTime(node, "DTSTART")
+	ev.End, _ = propTime(node, "DTEND")
+	ev.Created, _ = propTime(node, "CREATED")
+	ev.LastModified, _ = propTime(node, "LAST-MODIFIED")
+	ev.Stamp, _ = propTime(node, "DTSTAMP")
+
+	return ev
+}
+
+// ItemUID returns the event UID
+func (ev *Event) ItemUID() string { return ev.UID }
+
+// Encode returns the event wrapped in a VCALENDAR, or the content it was
+// parsed from with the modified properties replaced
+func (ev *Event) Encode() string {
+	if ev.raw != "" {
+		return update(ev.raw, "VEVENT", ev.properties)
+	}
+
+	e := &encoder{}
+	e.line("BEGIN", "VCALENDAR")
+	e.line("VERSION", "2.0")
+	e.line("PRODID", prodID)
+	e.line("BEGIN", "VEVENT")
+	stamped := *ev
+	stamped.Stamp = stamp(ev.Stamp)
+	stamped.properties(e)
+	e.line("END", "VEVENT")
+	e.line("END", "VCALENDAR")
+	return e.String()
+}
+
+// properties writes the properties modeled by Event
+func (ev *Event) properties(e *encoder) {
+	e.text("UID", ev.UID)
+	e.time("DTSTAMP", ev.Stamp, false)
+	e.time("CREATED", ev.Created, false)
+	e.time("LAST-MODIFIED", ev.LastModified, false)
+	e.time("DTSTART", ev.Start, ev.AllDay)
+	e.time("DTEND", ev.End, ev.AllDay)
+	e.text("SUMMARY", ev.Summary)
+	e.text("DESCRIPTION", ev.Description)
+	e.text("LOCATION", ev.Location)
+	e.text("STATUS", ev.Status)
+	e.categories(ev.Categories)
+}
+
+// Task is a VTODO, the AllDay flags report whether a time is a DATE value
+type Task struct {
+	UID             string
+	Summary         string
+	Description     string
+	Status          string
+	Priority        int
+	Start           time.Time
+	StartAllDay     bool
+	Due             time.Time
+	DueAllDay       bool
+	Completed       time.Time
+	CompletedAllDay bool
+	Categories      []string
+	Created         time.Time
+	LastModified    time.Time
+	Stamp           time.Time
+
+	// raw is the content the task was parsed from, empty for new tasks
+	raw string
+}
+
+var _ Item = &Task{}
+
+func parseTask(node *ical.Node) *Task {
+	t := &Task{
+		UID:         prop(node, "UID"),
+		Summary:     prop(node, "SUMMARY"),
+		Description: prop(node, "DESCRIPTION"),
+		Status:      prop(node, "STATUS"),
+		Categories:  categories(node),
+	}
+
+	t.Priority, _ = strconv.Atoi(prop(node, "PRIORITY"))
+	t.Start, t.StartAllDay = propTime(node, "DTSTART")
+	t.Due, t.DueAllDay = propTime(node, "DUE")
+	t.Completed, t.CompletedAllDay = propTime(node, "COMPLETED")
+	t.Created, _ = propTime(node, "CREATED")
+	t.LastModified, _ = propTime(node, "LAST-MODIFIED")
+	t.Stamp, _ = propTime(node, "DTSTAMP")
+
+	return t
+}
+
+// ItemUID returns the task UID
+func (t *Task) ItemUID() string { return t.UID }
+
+// Encode returns the task wrapped in a VCALENDAR, or the content it was
+// parsed from with the modified properties replaced
+func (t *Task) Encode() string {
+	if t.raw != "" {
+		return update(t.raw, "VTODO", t.properties)
+	}
+
+	e := &encoder{}
+	e.line("BEGIN", "VCALENDAR")
+	e.line("VERSION", "2.0")
+	e.line("PRODID", prodID)
+	e.line("BEGIN", "VTODO")
+	stamped := *t
+	stamped.Stamp = stamp(t.Stamp)
+	stamped.properties(e)
+	e.line("END", "VTODO")
+	e.line("END", "VCALENDAR")
+	return e.String()
+}
+
+// properties writes the properties modeled by Task
+func (t *Task) properties(e *encoder) {
+	e.text("UID", t.UID)
+	e.time("DTSTAMP", t.Stamp, false)
+	e.time("CREATED", t.Created, false)
+	e.time("LAST-MODIFIED", t.LastModified, false)
+	e.time("DTSTART", t.Start, t.StartAllDay)
+	e.time("DUE", t.Due, t.DueAllDay)
+	e.time("COMPLETED", t.Completed, t.CompletedAllDay)
+	e.text("SUMMARY", t.Summary)
+	e.text("DESCRIPTION", t.Description)
+	e.text("STATUS", t.Status)
+	if t.Priority != 0 {
+		e.line("PRIORITY", strconv.Itoa(t.Priority))
+	}
+	e.categories(t.Categories)
+}
+
+// stamp returns t or the current time if t is zero, as DTSTAMP is required
+func stamp(t time.Time) time.Time {
+	if t.IsZero() {
+		return time.Now()
+	}
+	return t
+}
@@ -0,0 +1,290 @@
+package pim
+
+import (
+	"strings"
+	"time"
+
+	"github.com/laurent22/ical-go"
+)
+
+// Contact is a VCARD
+type Contact struct {
+	UID           string
+	FormattedName string
+	Name          Name
+	Organization  string
+	Note          string
+	Emails        []Email
+	Phones        []Phone
+	Addresses     []Address
+	Categories    []string
+	Revision      time.Time
+
+	// raw is the content the contact was parsed from, empty for new contacts
+	raw string
+}
+
+// Name is the structured N property of a contact
+type Name struct {
+	Family     string
+	Given      string
+	Additional string
+	Prefix     string
+	Suffix     string
+}
+
+// Email is an EMAIL property, Type is lowercased (eg. home, work) and lists
+// several values separated by commas (eg. work,pref)
+type Email struct {
+	Type    string
+	Address string
+}
+
+// Phone is a TEL property, Type is lowercased (eg. cell, home, work) and lists
+// several values separated by commas (eg. cell,pref)
+type Phone struct {
+	Type   string
+	Number string
+}
+
+// Address is an ADR property, Type is lowercased (eg. home, work) and lists
+// several values separated by commas
+type Address struct {
+	Type       string
+	POBox      string
+	Extended   string
+	Street     string
+	Locality   string
+	Region     string
+	PostalCode string
+	Country    string
+}
+
+var _ Item = &Contact{}
+
+func parseContact(node *ical.Node, raw string) *Contact {
+	c := &Contact{
+		UID:           prop(node, "UID"),
+		FormattedName: prop(node, "FN"),
+		Note:          prop(node, "NOTE"),
+		Categories:    categories(node),
+	}
+
+	n := components(rawProp(node, "N"), 5)
+	c.Name = Name{Family: n[0], Given: n[1], Additional: n[2], Prefix: n[3], Suffix: n[4]}
+	c.Organization = components(rawProp(node, "ORG"), 1)[0]
+
+	// the typed properties are read from the content lines, as the nodes
+	// keep a single value per parameter
+	for _, p := range typedProps(raw, "EMAIL") {
+		c.Emails = append(c.Emails, Email{Type: p.typ, Address: unescape(p.value)})
+	}
+
+	for _, p := range typedProps(raw, "TEL") {
+		c.Phones = append(c.Phones, Phone{Type: p.typ, Number: unescape(p.value)})
+	}
+
+	for _, p := range typedProps(raw, "ADR") {
+		adr := components(p.value, 7)
+		c.Addresses = append(c.Addresses, Address{
+			Type:       p.typ,
+			POBox:      adr[0],
+			Extended:   adr[1],
+			Street:     adr[2],
+			Locality:   adr[3],
+			Region:     adr[4],
+			PostalCode: adr[5],
+			Country:    adr[6],
+		})
+	}
+
+	c.Revision, _ = propTime(node, "REV")
+
+	return c
+}
+
+// components splits a structured value in n unescaped components, missing
+// ones are empty
+func components(value string, n int) []string {
+	parts := make([]string, n)
+	for i, p := range splitUnescaped(value, ';') {
+		if i >= n {
+			break
+		}
+		parts[i] = unescape(p)
+	}
+	return parts
+}
+
+// structured escapes and joins the components of a structured value
+func structured(parts ...string) string {
+	for i, p := range parts {
+		parts[i] = escape(p)
+	}
+	return strings.Join(parts, ";")
+}
+
+// ItemUID returns the contact UID
+func (c *Contact) ItemUID() string { return c.UID }
+
+// Encode returns the contact as a vCard 3.0, or the content it was parsed
+// from with the modified properties replaced
+func (c *Contact) Encode() string {
+	if c.raw != "" {
+		return update(c.raw, "VCARD", c.properties)
+	}
+
+	e := &encoder{}
+	e.line("BEGIN", "VCARD")
+	e.line("VERSION", "3.0")
+	e.line("PRODID", prodID)
+	c.properties(e)
+	e.line("END", "VCARD")
+	return e.String()
+}
+
+// properties writes the properties modeled by Contact
+func (c *Contact) properties(e *encoder) {
+	e.text("UID", c.UID)
+	e.line("FN", escape(c.FormattedName))
+	n := c.Name
+	e.line("N", structured(n.Family, n.Given, n.Additional, n.Prefix, n.Suffix))
+	e.text("ORG", c.Organization)
+	e.text("NOTE", c.Note)
+
+	emails := make([]property, len(c.Emails))
+	for i, m := range c.Emails {
+		emails[i] = property{typ: m.Type, value: escape(m.Address)}
+	}
+	e.typedList("EMAIL", typedProps(c.raw, "EMAIL"), emails)
+
+	phones := make([]property, len(c.Phones))
+	for i, p := range c.Phones {
+		phones[i] = property{typ: p.Type, value: escape(p.Number)}
+	}
+	e.typedList("TEL", typedProps(c.raw, "TEL"), phones)
+
+	addresses := make([]property, len(c.Addresses))
+	for i, a := range c.Addresses {
+		addresses[i] = property{typ: a.Type, value: structured(
+			a.POBox, a.Extended, a.Street, a.Locality, a.Region, a.PostalCode, a.Country,
+		)}
+	}
+	e.typedList("ADR", typedProps(c.raw, "ADR"), addresses)
+
+	e.categories(c.Categories)
+	e.time("REV", c.Revision, false)
+}
+
+// property is a typed content line of a vCard
+type property struct {
+	// head is the name with its group and parameters, eg. item1.TEL;TYPE=CELL
+	head string
+	// typ are the values of the TYPE parameters, lowercased and separated
+	// by commas
+	typ   string
+	value string
+}
+
+// typedProps returns the properties of a vCard called name, with or without
+// a group prefix
+func typedProps(raw, name string) []property {
+	var found []property
+	depth := -1
+	for _, l := range unfold(raw) {
+		line := l[0]
+		for _, p := range l[1:] {
+			line += p[1:]
+		}
+
+		switch n := propName(line); {
+		case depth < 0:
+			if n == "BEGIN" && strings.EqualFold(value(line), "VCARD") {
+				depth = 0
+			}
+		case n == "BEGIN":
+			depth++
+		case n == "END":
+			depth--
+		case depth == 0 && n == name:
+			found = append(found, parseProperty(line))
+		}
+	}
+	return found
+}
+
+// parseProperty splits a content line in its head and value, collecting the
+// TYPE parameters, either repeated, listed or without a name (vCard 2.1)
+func parseProperty(line string) property {
+	var (
+		p      = property{head: line}
+		params []string
+		types  []string
+		quoted bool
+		start  int
+	)
+split:
+	for i := 0; i < len(line); i++ {
+		switch line[i] {
+		case '"':
+			quoted = !quoted
+		case ';', ':':
+			if quoted {
+				continue
+			}
+			params = append(params, line[start:i])
+			start = i + 1
+			if line[i] == ':' {
+				p.head, p.value = line[:i], line[i+1:]
+				break split
+			}
+		}
+	}
+
+	for i, param := range params {
+		if i == 0 {
+			continue
+		}
+		eq := strings.IndexByte(param, '=')
+		switch {
+		case eq < 0:
+			types = append(types, strings.ToLower(param))
+		case strings.EqualFold(param[:eq], "TYPE"):
+			for _, t := range strings.Split(param[eq+1:], ",") {
+				if t = strings.Trim(t, `"`); t != "" {
+					types = append(types, strings.ToLower(t))
+				}
+			}
+		}
+	}
+	p.typ = strings.Join(types, ",")
+	return p
+}
+
+// typedList writes the typed properties called name. The parsed properties
+// are written back with their group and parameters: an unchanged one as it
+// was, and a changed value with the head of a parsed property of its type.
+func (e *encoder) typedList(name string, parsed, props []property) {
+	used := make([]bool, len(parsed))
+	heads := make([]string, len(props))
+	reuse := func(same func(p, parsed property) bool) {
+		for i, p := range props {
+			for j := range parsed {
+				if heads[i] == "" && !used[j] && same(p, parsed[j]) {
+					heads[i], used[j] = parsed[j].head, true
+					break
+				}
+			}
+		}
+	}
+	reuse(func(p, parsed property) bool { return p.typ == parsed.typ && p.value == parsed.value })
+	reuse(func(p, parsed property) bool { return p.typ == parsed.typ })
+
+	for i, p := range props {
+		if heads[i] != "" {
+			e.line(heads[i], p.value)
+		} else {
+			e.typed(name, p.typ, p.value)
+		}
+	}
+}
@@ -0,0 +1,277 @@
+// Package pim turns decrypted entry contents into typed events, tasks and
+// contacts, and encodes them back into iCalendar/vCard.
+package pim
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gchaincl/go-etesync/api"
+	"github.com/laurent22/ical-go"
+)
+
+var (
+	// ErrUnknownComponent denotes content that is neither a VEVENT, VTODO nor VCARD
+	ErrUnknownComponent = errors.New("unknown component")
+)
+
+// Item is implemented by Event, Task and Contact
+type Item interface {
+	// ItemUID returns the UID property of the item
+	ItemUID() string
+	// Encode returns the item as an iCalendar or vCard string
+	Encode() string
+}
+
+// Parse parses an iCalendar or vCard string into an Event, Task or Contact.
+// The item keeps the string, so encoding it changes only the properties which
+// were modified.
+func Parse(content string) (Item, error) {
+	node, err := ical.ParseCalendar(content)
+	if err != nil {
+		return nil, err
+	}
+
+	switch node.Name {
+	case "VCARD":
+		c := parseContact(node, content)
+		c.raw = content
+		return c, nil
+	case "VCALENDAR":
+		if child := node.ChildByName("VEVENT"); child != nil {
+			node = child
+		} else if child := node.ChildByName("VTODO"); child != nil {
+			node = child
+		}
+	}
+
+	switch node.Name {
+	case "VEVENT":
+		ev := parseEvent(node)
+		ev.raw = content
+		return ev, nil
+	case "VTODO":
+		t := parseTask(node)
+		t.raw = content
+		return t, nil
+	}
+
+	return nil, ErrUnknownComponent
+}
+
+// FromEntry parses the content of a decrypted entry
+func FromEntry(ec *api.EntryContent) (Item, error) {
+	return Parse(ec.Content)
+}
+
+// ToEntry returns an EntryContent holding the encoded item
+func ToEntry(action string, item Item) *api.EntryContent {
+	return &api.EntryContent{Action: action, Content: item.Encode()}
+}
+
+// UID returns the UID of the item contained in an iCalendar or vCard string
+func UID(content string) (string, error) {
+	item, err := Parse(content)
+	if err != nil {
+		return "", err
+	}
+
+	return item.ItemUID(), nil
+}
+
+const (
+	dateFormat     = "20060102"
+	dateTimeFormat = "20060102T150405"
+	utcFormat      = "20060102T150405Z"
+)
+
+// prop returns the unescaped value of the first property called name
+func prop(node *ical.Node, name string) string {
+	return unescape(rawProp(node, name))
+}
+
+// rawProp returns the value of the first property called name as is
+func rawProp(node *ical.Node, name string) string {
+	if ps := props(node, name); len(ps) > 0 {
+		return ps[0].Value
+	}
+	return ""
+}
+
+// props returns the nodes of every property called name, with or without a
+// group prefix (eg. item1.EMAIL)
+func props(node *ical.Node, name string) []*ical.Node {
+	var found []*ical.Node
+	for _, child := range node.Children {
+		if propName(child.Name) == name {
+			found = append(found, child)
+		}
+	}
+	return found
+}
+
+// propTime parses a DATE or DATE-TIME property, the bool reports whether it
+// was a DATE value
+func propTime(node *ical.Node, name string) (time.Time, bool) {
+	ps := props(node, name)
+	if len(ps) == 0 {
+		return time.Time{}, false
+	}
+	p := ps[0]
+
+	if p.Parameters["VALUE"] == "DATE" || len(p.Value) == len(dateFormat) {
+		t, err := time.ParseInLocation(dateFormat, p.Value, time.UTC)
+		if err != nil {
+			return time.Time{}, false
+		}
+		return t, true
+	}
+
+	if strings.HasSuffix(p.Value, "Z") {
+		t, _ := time.Parse(utcFormat, p.Value)
+		return t, false
+	}
+
+	loc := time.Local
+	if tzid := p.Parameters["TZID"]; tzid != "" {
+		if l, err := time.LoadLocation(tzid); err == nil {
+			loc = l
+		}
+	}
+
+	t, _ := time.ParseInLocation(dateTimeFormat, p.Value, loc)
+	return t, false
+}
+
+// categories returns every value of every CATEGORIES property
+func categories(node *ical.Node) []string {
+	var cats []string
+	for _, p := range props(node, "CATEGORIES") {
+		for _, c := range splitUnescaped(p.Value, ',') {
+			if c = unescape(c); c != "" {
+				cats = append(cats, c)
+			}
+		}
+	}
+	return cats
+}
+
+// splitUnescaped splits s at every sep not preceded by a backslash
+func splitUnescaped(s string, sep byte) []string {
+	var (
+		parts []string
+		start int
+	)
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case sep:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
+
+var (
+	escaper   = strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\n", `\n`)
+	unescaper = strings.NewReplacer(`\\`, `\`, `\;`, ";", `\,`, ",", `\n`, "\n", `\N`, "\n")
+)
+
+func escape(s string) string   { return escaper.Replace(s) }
+func unescape(s string) string { return unescaper.Replace(s) }
+
+// encoder writes content lines folded at 75 octets
+type encoder struct {
+	lines []contentLine
+}
+
+// contentLine is a property written by the encoder, name is the property
+// name without its parameters
+type contentLine struct {
+	name string
+	text string
+}
+
+func (e *encoder) line(name, value string) {
+	e.lines = append(e.lines, contentLine{name: propName(name), text: name + ":" + value})
+}
+
+// propName returns the name of the property of a content line, uppercased
+// and without its group
+func propName(line string) string {
+	if i := strings.IndexAny(line, ";:"); i >= 0 {
+		line = line[:i]
+	}
+	if i := strings.IndexByte(line, '.'); i >= 0 {
+		line = line[i+1:]
+	}
+	return strings.ToUpper(line)
+}
+
+// fold folds a content line at 75 octets, without splitting a multi-octet
+// UTF-8 character
+func fold(l string) string {
+	var b strings.Builder
+	for len(l) > 75 {
+		n := 75
+		for n > 1 && !utf8.RuneStart(l[n]) {
+			n--
+		}
+		b.WriteString(l[:n] + "\r\n")
+		l = " " + l[n:]
+	}
+	b.WriteString(l + "\r\n")
+	return b.String()
+}
+
+// text writes a TEXT property, skipping it if empty
+func (e *encoder) text(name, value string) {
+	if value != "" {
+		e.line(name, escape(value))
+	}
+}
+
+// time writes a DATE-TIME property in UTC or a DATE property if date is
+// set, skipping it if zero
+func (e *encoder) time(name string, t time.Time, date bool) {
+	switch {
+	case t.IsZero():
+	case date:
+		e.line(name+";VALUE=DATE", t.Format(dateFormat))
+	default:
+		e.line(name, t.UTC().Format(utcFormat))
+	}
+}
+
+func (e *encoder) categories(cats []string) {
+	if len(cats) == 0 {
+		return
+	}
+
+	escaped := make([]string, len(cats))
+	for i, c := range cats {
+		escaped[i] = escape(c)
+	}
+	e.line("CATEGORIES", strings.Join(escaped, ","))
+}
+
+// typed writes a property with an optional TYPE parameter
+func (e *encoder) typed(name, typ, value string) {
+	if typ != "" {
+		name = fmt.Sprintf("%s;TYPE=%s", name, strings.ToUpper(typ))
+	}
+	e.line(name, value)
+}
+
+func (e *encoder) String() string {
+	var b strings.Builder
+	for _, l := range e.lines {
+		b.WriteString(fold(l.text))
+	}
+	return b.String()
+}
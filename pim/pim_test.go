@@ -0,0 +1,281 @@
+package pim
+
+import (
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gchaincl/go-etesync/api"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const vevent = "BEGIN:VCALENDAR\r\n" +
+	"VERSION:2.0\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"UID:event-1\r\n" +
+	"DTSTAMP:20180102T030405Z\r\n" +
+	"DTSTART:20180110T100000Z\r\n" +
+	"DTEND:20180110T110000Z\r\n" +
+	"SUMMARY:Meeting\\, with Bob\r\n" +
+	"LOCATION:Room 1\r\n" +
+	"STATUS:CONFIRMED\r\n" +
+	"CATEGORIES:work,meetings\r\n" +
+	"END:VEVENT\r\n" +
+	"END:VCALENDAR\r\n"
+
+const vtodo = "BEGIN:VCALENDAR\r\n" +
+	"VERSION:2.0\r\n" +
+	"BEGIN:VTODO\r\n" +
+	"UID:task-1\r\n" +
+	"DTSTAMP:20180102T030405Z\r\n" +
+	"DUE;VALUE=DATE:20180115\r\n" +
+	"SUMMARY:Buy milk\r\n" +
+	"STATUS:NEEDS-ACTION\r\n" +
+	"PRIORITY:1\r\n" +
+	"END:VTODO\r\n" +
+	"END:VCALENDAR\r\n"
+
+const vcard = "BEGIN:VCARD\r\n" +
+	"VERSION:3.0\r\n" +
+	"UID:contact-1\r\n" +
+	"FN:John Doe\r\n" +
+	"N:Doe;John;;;\r\n" +
+	"EMAIL;TYPE=WORK:john@work.com\r\n" +
+	"EMAIL;TYPE=HOME:john@home.com\r\n" +
+	"TEL;TYPE=CELL:+1 555 1234\r\n" +
+	"ADR;TYPE=HOME:;;1 Main St;Springfield;IL;62701;USA\r\n" +
+	"CATEGORIES:friends\r\n" +
+	"END:VCARD\r\n"
+
+func TestParseEvent(t *testing.T) {
+	item, err := Parse(vevent)
+	require.NoError(t, err)
+
+	ev, ok := item.(*Event)
+	require.True(t, ok)
+
+	assert.Equal(t, "event-1", ev.ItemUID())
+	assert.Equal(t, "Meeting, with Bob", ev.Summary)
+	assert.Equal(t, "Room 1", ev.Location)
+	assert.Equal(t, "CONFIRMED", ev.Status)
+	assert.Equal(t, time.Date(2018, 1, 10, 10, 0, 0, 0, time.UTC), ev.Start)
+	assert.Equal(t, time.Date(2018, 1, 10, 11, 0, 0, 0, time.UTC), ev.End)
+	assert.False(t, ev.AllDay)
+	assert.Equal(t, []string{"work", "meetings"}, ev.Categories)
+}
+
+func TestParseTask(t *testing.T) {
+	item, err := Parse(vtodo)
+	require.NoError(t, err)
+
+	task, ok := item.(*Task)
+	require.True(t, ok)
+
+	assert.Equal(t, "task-1", task.ItemUID())
+	assert.Equal(t, "Buy milk", task.Summary)
+	assert.Equal(t, "NEEDS-ACTION", task.Status)
+	assert.Equal(t, 1, task.Priority)
+	assert.Equal(t, time.Date(2018, 1, 15, 0, 0, 0, 0, time.UTC), task.Due)
+	assert.True(t, task.DueAllDay)
+	assert.False(t, task.StartAllDay)
+}
+
+func TestParseContact(t *testing.T) {
+	item, err := Parse(vcard)
+	require.NoError(t, err)
+
+	c, ok := item.(*Contact)
+	require.True(t, ok)
+
+	assert.Equal(t, "contact-1", c.ItemUID())
+	assert.Equal(t, "John Doe", c.FormattedName)
+	assert.Equal(t, Name{Family: "Doe", Given: "John"}, c.Name)
+	assert.Equal(t, []Email{
+		{Type: "work", Address: "john@work.com"},
+		{Type: "home", Address: "john@home.com"},
+	}, c.Emails)
+	assert.Equal(t, []Phone{{Type: "cell", Number: "+1 555 1234"}}, c.Phones)
+	assert.Equal(t, []Address{{
+		Type: "home", Street: "1 Main St", Locality: "Springfield",
+		Region: "IL", PostalCode: "62701", Country: "USA",
+	}}, c.Addresses)
+	assert.Equal(t, []string{"friends"}, c.Categories)
+}
+
+func TestRoundTrip(t *testing.T) {
+	for _, content := range []string{vevent, vtodo, vcard} {
+		item, err := Parse(content)
+		require.NoError(t, err)
+
+		ec := ToEntry(api.ActionAdd, item)
+		assert.Equal(t, api.ActionAdd, ec.Action)
+
+		parsed, err := FromEntry(ec)
+		require.NoError(t, err)
+		assert.Equal(t, item, parsed)
+	}
+
+	item, err := Parse(vtodo)
+	require.NoError(t, err)
+	task := *item.(*Task)
+	task.raw = ""
+	assert.Contains(t, task.Encode(), "\r\nDUE;VALUE=DATE:20180115\r\n")
+}
+
+const recurring = "BEGIN:VCALENDAR\r\n" +
+	"VERSION:2.0\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"UID:event-2\r\n" +
+	"DTSTAMP:20180102T030405Z\r\n" +
+	"DTSTART;TZID=Europe/Berlin:20180110T100000\r\n" +
+	"SUMMARY:Standup\r\n" +
+	"RRULE:FREQ=WEEKLY;BYDAY=MO,WE,FR\r\n" +
+	"ATTENDEE;CN=Jane Roe;PARTSTAT=ACCEPTED:mailto:jane@example.com\r\n" +
+	"X-UNKNOWN:kept\r\n" +
+	"BEGIN:VALARM\r\n" +
+	"ACTION:DISPLAY\r\n" +
+	"SUMMARY:Alarm\r\n" +
+	"TRIGGER:-PT15M\r\n" +
+	"END:VALARM\r\n" +
+	"END:VEVENT\r\n" +
+	"END:VCALENDAR\r\n"
+
+func TestEncodeKeepsContent(t *testing.T) {
+	item, err := Parse(recurring)
+	require.NoError(t, err)
+	assert.Equal(t, recurring, item.Encode())
+
+	t.Run("changes only the modified properties", func(t *testing.T) {
+		ev := *item.(*Event)
+		ev.Summary = "Daily standup"
+		ev.Location = "Room 2"
+
+		encoded := ev.Encode()
+		expected := strings.Replace(recurring, "SUMMARY:Standup\r\n", "SUMMARY:Daily standup\r\n", 1)
+		expected = strings.Replace(expected, "END:VEVENT", "LOCATION:Room 2\r\nEND:VEVENT", 1)
+		assert.Equal(t, expected, encoded)
+
+		parsed, err := Parse(encoded)
+		require.NoError(t, err)
+		assert.Equal(t, "Daily standup", parsed.(*Event).Summary)
+		assert.Equal(t, "Room 2", parsed.(*Event).Location)
+	})
+
+	t.Run("removes cleared properties", func(t *testing.T) {
+		c, err := Parse(vcard)
+		require.NoError(t, err)
+
+		contact := *c.(*Contact)
+		contact.Emails = contact.Emails[:1]
+		contact.Categories = nil
+		encoded := contact.Encode()
+		assert.NotContains(t, encoded, "john@home.com")
+		assert.NotContains(t, encoded, "CATEGORIES")
+		assert.Contains(t, encoded, "EMAIL;TYPE=WORK:john@work.com\r\n")
+	})
+}
+
+func TestUID(t *testing.T) {
+	uid, err := UID(vcard)
+	require.NoError(t, err)
+	assert.Equal(t, "contact-1", uid)
+
+	_, err = UID("BEGIN:VJOURNAL\r\nUID:x\r\nEND:VJOURNAL\r\n")
+	assert.Equal(t, ErrUnknownComponent, err)
+}
+
+func TestParseContactEmptyValues(t *testing.T) {
+	item, err := Parse("BEGIN:VCARD\r\n" +
+		"VERSION:3.0\r\n" +
+		"UID:contact-2\r\n" +
+		"FN:Jane\r\n" +
+		"N:Doe\r\n" +
+		"ADR:\r\n" +
+		"ADR;TYPE=WORK:;;2 Main St\r\n" +
+		"END:VCARD\r\n")
+	require.NoError(t, err)
+
+	c := item.(*Contact)
+	assert.Equal(t, Name{Family: "Doe"}, c.Name)
+	assert.Equal(t, []Address{{}, {Type: "work", Street: "2 Main St"}}, c.Addresses)
+}
+
+func TestFoldKeepsCharacters(t *testing.T) {
+	summary := strings.Repeat("é", 60)
+	ev := &Event{UID: "event-3", Summary: summary, Start: time.Date(2018, 1, 10, 10, 0, 0, 0, time.UTC)}
+
+	item, err := Parse(ev.Encode())
+	require.NoError(t, err)
+	assert.Equal(t, summary, item.(*Event).Summary)
+
+	// the patched content is folded too
+	edited := *item.(*Event)
+	edited.Summary = "ü" + summary
+	encoded := edited.Encode()
+	for _, l := range strings.Split(encoded, "\r\n") {
+		assert.True(t, len(l) <= 75, "line longer than 75 octets: %q", l)
+		assert.True(t, utf8.ValidString(l), "split character: %q", l)
+	}
+
+	parsed, err := Parse(encoded)
+	require.NoError(t, err)
+	assert.Equal(t, "ü"+summary, parsed.(*Event).Summary)
+}
+
+const vcardParams = "BEGIN:VCARD\r\n" +
+	"VERSION:3.0\r\n" +
+	"UID:contact-3\r\n" +
+	"FN:Jane Roe\r\n" +
+	"N:Roe;Jane;;;\r\n" +
+	"item1.EMAIL;TYPE=INTERNET;TYPE=HOME:jane@home.com\r\n" +
+	"item1.X-ABLabel:_$!<Home>!$_\r\n" +
+	"EMAIL;TYPE=\"work,pref\";X-CUSTOM=1:jane@work.com\r\n" +
+	"TEL;TYPE=CELL;TYPE=pref:+1 555 1234\r\n" +
+	"TEL;WORK;VOICE:+1 555 5678\r\n" +
+	"END:VCARD\r\n"
+
+func TestParseContactParameters(t *testing.T) {
+	item, err := Parse(vcardParams)
+	require.NoError(t, err)
+	c := item.(*Contact)
+
+	t.Run("every type is kept", func(t *testing.T) {
+		assert.Equal(t, []Phone{
+			{Type: "cell,pref", Number: "+1 555 1234"},
+			{Type: "work,voice", Number: "+1 555 5678"},
+		}, c.Phones)
+	})
+
+	t.Run("grouped properties are parsed", func(t *testing.T) {
+		assert.Equal(t, []Email{
+			{Type: "internet,home", Address: "jane@home.com"},
+			{Type: "work,pref", Address: "jane@work.com"},
+		}, c.Emails)
+	})
+
+	t.Run("unchanged entries keep their parameters", func(t *testing.T) {
+		edited := *c
+		edited.Phones = append(edited.Phones[1:], Phone{Type: "home", Number: "+1 555 0000"})
+		edited.Emails = []Email{c.Emails[0], {Type: "work,pref", Address: "jane@example.com"}}
+
+		encoded := edited.Encode()
+		assert.NotContains(t, encoded, "+1 555 1234")
+		assert.Contains(t, encoded, "\r\nTEL;WORK;VOICE:+1 555 5678\r\n")
+		assert.Contains(t, encoded, "\r\nTEL;TYPE=HOME:+1 555 0000\r\n")
+		assert.Contains(t, encoded, "\r\nitem1.EMAIL;TYPE=INTERNET;TYPE=HOME:jane@home.com\r\n")
+		assert.Contains(t, encoded, "\r\nitem1.X-ABLabel:_$!<Home>!$_\r\n")
+		assert.Contains(t, encoded, "\r\nEMAIL;TYPE=\"work,pref\";X-CUSTOM=1:jane@example.com\r\n")
+
+		parsed, err := Parse(encoded)
+		require.NoError(t, err)
+		assert.Equal(t, edited.Emails, parsed.(*Contact).Emails)
+		assert.Equal(t, edited.Phones, parsed.(*Contact).Phones)
+	})
+
+	t.Run("new contacts list every type", func(t *testing.T) {
+		fresh := Contact{UID: "contact-4", Phones: c.Phones}
+		assert.Contains(t, fresh.Encode(), "\r\nTEL;TYPE=CELL,PREF:+1 555 1234\r\n")
+	})
+}
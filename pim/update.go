@@ -0,0 +1,137 @@
+package pim
+
+import (
+	"strings"
+)
+
+// modeled is implemented by the items writing the properties they model
+type modeled interface {
+	properties(e *encoder)
+}
+
+// update returns the content an item was parsed from replacing the properties
+// written by props which differ from the parsed ones. The properties which
+// aren't modeled (eg. RRULE, ATTENDEE or a VALARM) and the unchanged ones are
+// kept as they were.
+func update(raw, component string, props func(*encoder)) string {
+	parsed, err := Parse(raw)
+	if err != nil {
+		return raw
+	}
+	old, ok := parsed.(modeled)
+	if !ok {
+		return raw
+	}
+
+	before, after := &encoder{}, &encoder{}
+	old.properties(before)
+	props(after)
+
+	was, is := group(before), group(after)
+	changed := make(map[string]bool)
+	for name, lines := range is {
+		if !equalLines(lines, was[name]) {
+			changed[name] = true
+		}
+	}
+	for name := range was {
+		if _, ok := is[name]; !ok {
+			changed[name] = true
+		}
+	}
+	if len(changed) == 0 {
+		return raw
+	}
+
+	var b strings.Builder
+	written := make(map[string]bool)
+	write := func(name string) {
+		if written[name] {
+			return
+		}
+		written[name] = true
+		for _, l := range is[name] {
+			b.WriteString(fold(l))
+		}
+	}
+
+	// depth is the nesting within the component, -1 outside of it
+	depth, done := -1, false
+	for _, l := range unfold(raw) {
+		name := propName(l[0])
+		switch {
+		case done:
+		case depth < 0:
+			if name == "BEGIN" && strings.EqualFold(value(l[0]), component) {
+				depth = 0
+			}
+		case name == "BEGIN":
+			depth++
+		case name == "END" && depth > 0:
+			depth--
+		case name == "END":
+			// the properties which weren't set go at the end
+			for _, p := range after.lines {
+				if changed[p.name] {
+					write(p.name)
+				}
+			}
+			done = true
+		case depth == 0 && changed[name]:
+			// the property is replaced where it first appeared
+			write(name)
+			continue
+		}
+
+		for _, p := range l {
+			b.WriteString(p + "\r\n")
+		}
+	}
+	return b.String()
+}
+
+// group returns the content lines of every property written by e
+func group(e *encoder) map[string][]string {
+	lines := make(map[string][]string)
+	for _, l := range e.lines {
+		lines[l.name] = append(lines[l.name], l.text)
+	}
+	return lines
+}
+
+func equalLines(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// unfold splits content in content lines, each one made of the physical
+// lines it was folded into
+func unfold(content string) [][]string {
+	var lines [][]string
+	for _, l := range strings.Split(content, "\n") {
+		l = strings.TrimSuffix(l, "\r")
+		switch {
+		case l == "":
+		case (l[0] == ' ' || l[0] == '\t') && len(lines) > 0:
+			lines[len(lines)-1] = append(lines[len(lines)-1], l)
+		default:
+			lines = append(lines, []string{l})
+		}
+	}
+	return lines
+}
+
+// value returns the value of a content line
+func value(line string) string {
+	if i := strings.IndexByte(line, ':'); i >= 0 {
+		return line[i+1:]
+	}
+	return ""
+}
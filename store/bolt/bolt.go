@@ -0,0 +1,561 @@
+// Package bolt implements store.Store on top of bbolt, an embedded pure Go
+// key/value database.
+package bolt
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/gchaincl/go-etesync/api"
+	"github.com/gchaincl/go-etesync/store"
+	"go.etcd.io/bbolt"
+)
+
+const (
+	journalsBucket  = "journals"
+	entriesBucket   = "entries"
+	itemsBucket     = "items"
+	outboxBucket    = "outbox"
+	conflictsBucket = "conflicts"
+	syncBucket      = "sync"
+	// accountsBucket holds the buckets of every account but the default one,
+	// which uses the top level buckets
+	accountsBucket = "accounts"
+)
+
+var _ store.Store = &Store{}
+
+type Store struct {
+	db      *bbolt.DB
+	tx      *bbolt.Tx
+	account string
+}
+
+// NewStore opens, or creates, the database file at path
+func NewStore(path string) (*Store, error) {
+	db, err := bbolt.Open(path, 0600, &bbolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+
+	return &Store{db: db}, nil
+}
+
+func (s *Store) Close() {
+	s.db.Close()
+}
+
+func (s *Store) view(fn func(*bbolt.Tx) error) error {
+	if s.tx != nil {
+		return fn(s.tx)
+	}
+	return s.db.View(fn)
+}
+
+func (s *Store) update(fn func(*bbolt.Tx) error) error {
+	if s.tx != nil {
+		return fn(s.tx)
+	}
+	return s.db.Update(fn)
+}
+
+func (s *Store) WithTx(fn func(store.Store) error) error {
+	if s.tx != nil {
+		return fn(s)
+	}
+
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		return fn(&Store{db: s.db, tx: tx, account: s.account})
+	})
+}
+
+func (s *Store) ForAccount(account string) store.Store {
+	return &Store{db: s.db, tx: s.tx, account: account}
+}
+
+// path returns the path of a bucket of the store account
+func (s *Store) path(name ...string) []string {
+	if s.account == "" {
+		return name
+	}
+	return append([]string{accountsBucket, s.account}, name...)
+}
+
+func (s *Store) Accounts() ([]string, error) {
+	var accounts []string
+	err := s.view(func(tx *bbolt.Tx) error {
+		if openList(tx, journalsBucket).count() > 0 {
+			accounts = append(accounts, "")
+		}
+
+		b := tx.Bucket([]byte(accountsBucket))
+		if b == nil {
+			return nil
+		}
+
+		// keys are sorted, and come after the empty account
+		return b.ForEach(func(k, _ []byte) error {
+			if openList(tx, accountsBucket, string(k), journalsBucket).count() > 0 {
+				accounts = append(accounts, string(k))
+			}
+			return nil
+		})
+	})
+	return accounts, err
+}
+
+// entry is a stored api.Entry, StoredAt is used by CompactEntries
+type entry struct {
+	*api.Entry
+	StoredAt time.Time `json:"storedAt"`
+}
+
+func (s *Store) CreateEntry(j string, e *api.Entry) error {
+	return s.update(func(tx *bbolt.Tx) error {
+		l, err := createList(tx, s.path(entriesBucket, j)...)
+		if err != nil {
+			return err
+		}
+		return l.append(e.UID, &entry{Entry: e, StoredAt: time.Now()})
+	})
+}
+
+func (s *Store) GetEntry(j string, uid string) (*api.Entry, error) {
+	e := &api.Entry{}
+	err := s.view(func(tx *bbolt.Tx) error {
+		return openList(tx, s.path(entriesBucket, j)...).get(uid, e)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+func (s *Store) GetEntries(j string) (api.Entries, error) {
+	return s.EntriesAfter(j, "", 0)
+}
+
+func (s *Store) EntriesAfter(j string, uid string, limit int) (api.Entries, error) {
+	var entries api.Entries
+	err := s.view(func(tx *bbolt.Tx) error {
+		return openList(tx, s.path(entriesBucket, j)...).after(uid, limit, func(data []byte) error {
+			e := &api.Entry{}
+			if err := json.Unmarshal(data, e); err != nil {
+				return err
+			}
+			entries = append(entries, e)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+func (s *Store) CountEntries(j string) (int, error) {
+	var count int
+	err := s.view(func(tx *bbolt.Tx) error {
+		count = openList(tx, s.path(entriesBucket, j)...).count()
+		return nil
+	})
+	return count, err
+}
+
+func (s *Store) CompactEntries(j string, r store.Retention) (int, error) {
+	keep := r.Count
+	if keep < 1 {
+		keep = 1
+	}
+
+	var deleted int
+	err := s.update(func(tx *bbolt.Tx) error {
+		l := openList(tx, s.path(entriesBucket, j)...)
+
+		var err error
+		deleted, err = l.deleteFirst(l.count()-keep, func(data []byte) (string, error) {
+			e := &entry{}
+			if err := json.Unmarshal(data, e); err != nil {
+				return "", err
+			}
+
+			// entries stored before StoredAt was added have it zero
+			if r.Since.IsZero() || e.StoredAt.Before(r.Since) {
+				return e.UID, nil
+			}
+			return "", nil
+		})
+		return err
+	})
+	return deleted, err
+}
+
+func (s *Store) LastEntry(j string) (*api.Entry, error) {
+	e := &api.Entry{}
+	err := s.view(func(tx *bbolt.Tx) error {
+		return openList(tx, s.path(entriesBucket, j)...).last(e)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+func (s *Store) CreateJournal(j *api.Journal) error {
+	return s.update(func(tx *bbolt.Tx) error {
+		l, err := createList(tx, s.path(journalsBucket)...)
+		if err != nil {
+			return err
+		}
+		return l.append(j.UID, j)
+	})
+}
+
+func (s *Store) UpdateJournal(j *api.Journal) error {
+	return s.update(func(tx *bbolt.Tx) error {
+		l := openList(tx, s.path(journalsBucket)...)
+		if _, err := l.key(j.UID); err != nil {
+			return err
+		}
+		return l.put(j.UID, j)
+	})
+}
+
+func (s *Store) GetJournal(uid string) (*api.Journal, error) {
+	j := &api.Journal{}
+	err := s.view(func(tx *bbolt.Tx) error {
+		return openList(tx, s.path(journalsBucket)...).get(uid, j)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return j, nil
+}
+
+func (s *Store) GetJournals() (api.Journals, error) {
+	var journals api.Journals
+	err := s.view(func(tx *bbolt.Tx) error {
+		return openList(tx, s.path(journalsBucket)...).after("", 0, func(data []byte) error {
+			j := &api.Journal{}
+			if err := json.Unmarshal(data, j); err != nil {
+				return err
+			}
+			journals = append(journals, j)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return journals, nil
+}
+
+func (s *Store) DeleteJournal(uid string) error {
+	return s.update(func(tx *bbolt.Tx) error {
+		return openList(tx, s.path(journalsBucket)...).delete(uid)
+	})
+}
+
+func (s *Store) PurgeJournal(uid string) error {
+	return s.update(func(tx *bbolt.Tx) error {
+		err := openList(tx, s.path(journalsBucket)...).delete(uid)
+		if err != nil && err != store.ErrRecordNotFound {
+			return err
+		}
+
+		if err := deleteBucket(tx, s.path(entriesBucket, uid)...); err != nil {
+			return err
+		}
+		if err := deleteBucket(tx, s.path(itemsBucket, uid)...); err != nil {
+			return err
+		}
+
+		if b := bucket(tx, s.path(syncBucket)...); b != nil {
+			if err := b.Delete([]byte(uid)); err != nil {
+				return err
+			}
+		}
+
+		if err := deleteJournalRecords(bucket(tx, s.path(outboxBucket)...), uid); err != nil {
+			return err
+		}
+		return deleteJournalRecords(bucket(tx, s.path(conflictsBucket)...), uid)
+	})
+}
+
+// deleteJournalRecords deletes the changes or conflicts of a journal from b,
+// which may be nil
+func deleteJournalRecords(b *bbolt.Bucket, uid string) error {
+	if b == nil {
+		return nil
+	}
+
+	// keys can't be deleted while iterating
+	var keys [][]byte
+	err := b.ForEach(func(k, data []byte) error {
+		var r struct{ JournalUID string }
+		if err := json.Unmarshal(data, &r); err != nil {
+			return err
+		}
+		if r.JournalUID == uid {
+			keys = append(keys, k)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, k := range keys {
+		if err := b.Delete(k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Store) PutItem(j string, i *store.Item) error {
+	return s.update(func(tx *bbolt.Tx) error {
+		l, err := createList(tx, s.path(itemsBucket, j)...)
+		if err != nil {
+			return err
+		}
+		return l.put(i.UID, i)
+	})
+}
+
+func (s *Store) DeleteItem(j string, uid string) error {
+	return s.update(func(tx *bbolt.Tx) error {
+		return openList(tx, s.path(itemsBucket, j)...).delete(uid)
+	})
+}
+
+func (s *Store) Items(j string) (store.Items, error) {
+	var items store.Items
+	err := s.view(func(tx *bbolt.Tx) error {
+		return openList(tx, s.path(itemsBucket, j)...).after("", 0, func(data []byte) error {
+			i := &store.Item{}
+			if err := json.Unmarshal(data, i); err != nil {
+				return err
+			}
+			items = append(items, i)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+func (s *Store) Item(j string, uid string) (*store.Item, error) {
+	i := &store.Item{}
+	err := s.view(func(tx *bbolt.Tx) error {
+		return openList(tx, s.path(itemsBucket, j)...).get(uid, i)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return i, nil
+}
+
+func (s *Store) EnqueueChange(c *store.Change) error {
+	return s.update(func(tx *bbolt.Tx) error {
+		b, err := createBucket(tx, s.path(outboxBucket)...)
+		if err != nil {
+			return err
+		}
+
+		id, err := b.NextSequence()
+		if err != nil {
+			return err
+		}
+
+		c.ID = id
+		data, err := json.Marshal(c)
+		if err != nil {
+			return err
+		}
+		return b.Put(itob(id), data)
+	})
+}
+
+func (s *Store) PendingChanges(j string) (store.Changes, error) {
+	var changes store.Changes
+	err := s.view(func(tx *bbolt.Tx) error {
+		b := bucket(tx, s.path(outboxBucket)...)
+		if b == nil {
+			return nil
+		}
+
+		return b.ForEach(func(_, data []byte) error {
+			c := &store.Change{}
+			if err := json.Unmarshal(data, c); err != nil {
+				return err
+			}
+
+			if c.SentAt.IsZero() && (j == "" || c.JournalUID == j) {
+				changes = append(changes, c)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return changes, nil
+}
+
+// change calls fn with the outbox bucket and the change given its id
+func (s *Store) change(id uint64, fn func(*bbolt.Bucket, *store.Change) error) error {
+	return s.update(func(tx *bbolt.Tx) error {
+		b := bucket(tx, s.path(outboxBucket)...)
+		if b == nil {
+			return store.ErrRecordNotFound
+		}
+
+		data := b.Get(itob(id))
+		if data == nil {
+			return store.ErrRecordNotFound
+		}
+
+		c := &store.Change{}
+		if err := json.Unmarshal(data, c); err != nil {
+			return err
+		}
+		return fn(b, c)
+	})
+}
+
+func (s *Store) MarkChangeSent(id uint64) error {
+	return s.change(id, func(b *bbolt.Bucket, c *store.Change) error {
+		c.SentAt = time.Now()
+		data, err := json.Marshal(c)
+		if err != nil {
+			return err
+		}
+		return b.Put(itob(id), data)
+	})
+}
+
+func (s *Store) DiscardChange(id uint64) error {
+	return s.change(id, func(b *bbolt.Bucket, _ *store.Change) error {
+		return b.Delete(itob(id))
+	})
+}
+
+func (s *Store) AddConflict(c *store.Conflict) error {
+	return s.update(func(tx *bbolt.Tx) error {
+		b, err := createBucket(tx, s.path(conflictsBucket)...)
+		if err != nil {
+			return err
+		}
+
+		id, err := b.NextSequence()
+		if err != nil {
+			return err
+		}
+
+		c.ID = id
+		data, err := json.Marshal(c)
+		if err != nil {
+			return err
+		}
+		return b.Put(itob(id), data)
+	})
+}
+
+func (s *Store) Conflicts(j string) (store.Conflicts, error) {
+	var conflicts store.Conflicts
+	err := s.view(func(tx *bbolt.Tx) error {
+		b := bucket(tx, s.path(conflictsBucket)...)
+		if b == nil {
+			return nil
+		}
+
+		return b.ForEach(func(_, data []byte) error {
+			c := &store.Conflict{}
+			if err := json.Unmarshal(data, c); err != nil {
+				return err
+			}
+
+			if j == "" || c.JournalUID == j {
+				conflicts = append(conflicts, c)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return conflicts, nil
+}
+
+func (s *Store) DeleteConflict(id uint64) error {
+	return s.update(func(tx *bbolt.Tx) error {
+		b := bucket(tx, s.path(conflictsBucket)...)
+		if b == nil || b.Get(itob(id)) == nil {
+			return store.ErrRecordNotFound
+		}
+		return b.Delete(itob(id))
+	})
+}
+
+func (s *Store) PutSyncState(st *store.SyncState) error {
+	return s.update(func(tx *bbolt.Tx) error {
+		b, err := createBucket(tx, s.path(syncBucket)...)
+		if err != nil {
+			return err
+		}
+
+		data, err := json.Marshal(st)
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte(st.JournalUID), data)
+	})
+}
+
+func (s *Store) SyncState(j string) (*store.SyncState, error) {
+	st := &store.SyncState{}
+	err := s.view(func(tx *bbolt.Tx) error {
+		b := bucket(tx, s.path(syncBucket)...)
+		if b == nil {
+			return store.ErrRecordNotFound
+		}
+
+		data := b.Get([]byte(j))
+		if data == nil {
+			return store.ErrRecordNotFound
+		}
+		return json.Unmarshal(data, st)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return st, nil
+}
+
+func (s *Store) SyncStates() (store.SyncStates, error) {
+	var states store.SyncStates
+	err := s.view(func(tx *bbolt.Tx) error {
+		b := bucket(tx, s.path(syncBucket)...)
+		if b == nil {
+			return nil
+		}
+
+		// keys are journal uids, which bbolt keeps sorted
+		return b.ForEach(func(_, data []byte) error {
+			st := &store.SyncState{}
+			if err := json.Unmarshal(data, st); err != nil {
+				return err
+			}
+			states = append(states, st)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return states, nil
+}
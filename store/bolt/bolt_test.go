@@ -0,0 +1,28 @@
+package bolt
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gchaincl/go-etesync/store"
+	"github.com/gchaincl/go-etesync/store/storetest"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBolt(t *testing.T) {
+	f := func(t *testing.T) (store.Store, func()) {
+		dir, err := ioutil.TempDir("", "etesync-bolt")
+		require.NoError(t, err)
+
+		s, err := NewStore(filepath.Join(dir, "test.db"))
+		require.NoError(t, err)
+
+		return s, func() {
+			s.Close()
+			os.RemoveAll(dir)
+		}
+	}
+	storetest.TestSuite(t, f)
+}
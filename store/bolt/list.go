@@ -0,0 +1,252 @@
+package bolt
+
+import (
+	"encoding/binary"
+	"encoding/json"
+
+	"github.com/gchaincl/go-etesync/store"
+	"go.etcd.io/bbolt"
+)
+
+var (
+	seqBucket = []byte("seq")
+	idxBucket = []byte("idx")
+)
+
+// list is an insertion ordered collection of JSON encoded values indexed by
+// uid. Values are keyed by sequence in the seq bucket while the idx bucket
+// maps every uid to its sequence.
+// A nil list behaves as an empty one, so missing buckets can be read.
+type list struct {
+	seq *bbolt.Bucket
+	idx *bbolt.Bucket
+}
+
+// bucket returns the bucket nested under path, or nil if it doesn't exist
+func bucket(tx *bbolt.Tx, path ...string) *bbolt.Bucket {
+	b := tx.Bucket([]byte(path[0]))
+	for _, p := range path[1:] {
+		if b == nil {
+			return nil
+		}
+		b = b.Bucket([]byte(p))
+	}
+	return b
+}
+
+// createBucket returns the bucket nested under path creating it if needed
+func createBucket(tx *bbolt.Tx, path ...string) (*bbolt.Bucket, error) {
+	b, err := tx.CreateBucketIfNotExists([]byte(path[0]))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, p := range path[1:] {
+		if b, err = b.CreateBucketIfNotExists([]byte(p)); err != nil {
+			return nil, err
+		}
+	}
+	return b, nil
+}
+
+// deleteBucket removes the bucket nested under path, if it exists
+func deleteBucket(tx *bbolt.Tx, path ...string) error {
+	if len(path) == 1 {
+		if tx.Bucket([]byte(path[0])) == nil {
+			return nil
+		}
+		return tx.DeleteBucket([]byte(path[0]))
+	}
+
+	parent := bucket(tx, path[:len(path)-1]...)
+	name := []byte(path[len(path)-1])
+	if parent == nil || parent.Bucket(name) == nil {
+		return nil
+	}
+	return parent.DeleteBucket(name)
+}
+
+// openList returns the list stored under path, or nil if it doesn't exist
+func openList(tx *bbolt.Tx, path ...string) *list {
+	b := bucket(tx, path...)
+	if b == nil {
+		return nil
+	}
+	return &list{seq: b.Bucket(seqBucket), idx: b.Bucket(idxBucket)}
+}
+
+// createList returns the list stored under path creating it if needed
+func createList(tx *bbolt.Tx, path ...string) (*list, error) {
+	b, err := createBucket(tx, path...)
+	if err != nil {
+		return nil, err
+	}
+
+	l := &list{}
+	if l.seq, err = b.CreateBucketIfNotExists(seqBucket); err != nil {
+		return nil, err
+	}
+	if l.idx, err = b.CreateBucketIfNotExists(idxBucket); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// itob encodes a sequence as a sortable key
+func itob(v uint64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, v)
+	return b
+}
+
+// append adds v at the end of the list
+func (l *list) append(uid string, v interface{}) error {
+	seq, err := l.seq.NextSequence()
+	if err != nil {
+		return err
+	}
+
+	return l.write(uid, itob(seq), v)
+}
+
+// put replaces the value of uid keeping its position, or appends it
+func (l *list) put(uid string, v interface{}) error {
+	if key := l.idx.Get([]byte(uid)); key != nil {
+		return l.write(uid, key, v)
+	}
+	return l.append(uid, v)
+}
+
+func (l *list) write(uid string, key []byte, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	if err := l.seq.Put(key, data); err != nil {
+		return err
+	}
+	return l.idx.Put([]byte(uid), key)
+}
+
+// key returns the sequence key of uid
+func (l *list) key(uid string) ([]byte, error) {
+	if l == nil {
+		return nil, store.ErrRecordNotFound
+	}
+
+	key := l.idx.Get([]byte(uid))
+	if key == nil {
+		return nil, store.ErrRecordNotFound
+	}
+	return key, nil
+}
+
+// get decodes the value of uid into v
+func (l *list) get(uid string, v interface{}) error {
+	key, err := l.key(uid)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(l.seq.Get(key), v)
+}
+
+// delete removes the value of uid
+func (l *list) delete(uid string) error {
+	key, err := l.key(uid)
+	if err != nil {
+		return err
+	}
+
+	if err := l.seq.Delete(key); err != nil {
+		return err
+	}
+	return l.idx.Delete([]byte(uid))
+}
+
+// deleteFirst removes values among the first n, fn returns the uid of the
+// given value if it must be deleted or an empty string to keep it
+func (l *list) deleteFirst(n int, fn func([]byte) (string, error)) (int, error) {
+	if l == nil {
+		return 0, nil
+	}
+
+	// keys can't be deleted while iterating
+	var keys [][]byte
+	var uids []string
+	c := l.seq.Cursor()
+	k, v := c.First()
+	for i := 0; k != nil && i < n; i++ {
+		uid, err := fn(v)
+		if err != nil {
+			return 0, err
+		}
+		if uid != "" {
+			keys = append(keys, k)
+			uids = append(uids, uid)
+		}
+		k, v = c.Next()
+	}
+
+	for i, k := range keys {
+		if err := l.seq.Delete(k); err != nil {
+			return 0, err
+		}
+		if err := l.idx.Delete([]byte(uids[i])); err != nil {
+			return 0, err
+		}
+	}
+	return len(keys), nil
+}
+
+// last decodes the last value of the list into v
+func (l *list) last(v interface{}) error {
+	if l == nil {
+		return store.ErrRecordNotFound
+	}
+
+	key, data := l.seq.Cursor().Last()
+	if key == nil {
+		return store.ErrRecordNotFound
+	}
+	return json.Unmarshal(data, v)
+}
+
+// after calls fn with up to limit values following uid, or from the first
+// value if uid is empty. A limit <= 0 means no limit.
+func (l *list) after(uid string, limit int, fn func([]byte) error) error {
+	if l == nil {
+		if uid != "" {
+			return store.ErrRecordNotFound
+		}
+		return nil
+	}
+
+	c := l.seq.Cursor()
+	k, v := c.First()
+	if uid != "" {
+		key, err := l.key(uid)
+		if err != nil {
+			return err
+		}
+		c.Seek(key)
+		k, v = c.Next()
+	}
+
+	for n := 0; k != nil && (limit <= 0 || n < limit); n++ {
+		if err := fn(v); err != nil {
+			return err
+		}
+		k, v = c.Next()
+	}
+	return nil
+}
+
+// count returns the number of values in the list
+func (l *list) count() int {
+	if l == nil {
+		return 0
+	}
+	return l.seq.Stats().KeyN
+}
@@ -0,0 +1,28 @@
+package store
+
+// Document is the searchable plain text of an item
+type Document struct {
+	JournalUID string
+	ItemUID    string
+	// Kind is the kind of item, eg. event, task or contact
+	Kind string
+	Text string
+}
+
+// SearchFilter restricts the search results, empty fields match everything
+type SearchFilter struct {
+	JournalUIDs []string
+	Kinds       []string
+	Limit       int
+}
+
+// Index is a full-text index over decrypted items.
+// As it stores plain text it is opt-in and kept apart from the Store.
+type Index interface {
+	// IndexDocument adds or replaces the document of an item
+	IndexDocument(doc *Document) error
+	RemoveDocument(journalUID string, itemUID string) error
+	// Search returns the documents matching every term of query
+	Search(query string, filter SearchFilter) ([]*Document, error)
+	CountDocuments() (int, error)
+}
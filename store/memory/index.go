@@ -0,0 +1,152 @@
+package memory
+
+import (
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/gchaincl/go-etesync/store"
+)
+
+// Index is an in memory store.Index matching the documents as the sqlite FTS
+// index does: the text is split in case insensitive alphanumeric tokens,
+// alphanumeric terms match as token prefixes and the others as phrases.
+type Index struct {
+	mu   sync.RWMutex
+	docs []*store.Document
+}
+
+var _ store.Index = &Index{}
+
+func NewIndex() *Index {
+	return &Index{}
+}
+
+func (i *Index) find(j string, uid string) int {
+	for n, doc := range i.docs {
+		if doc.JournalUID == j && doc.ItemUID == uid {
+			return n
+		}
+	}
+	return -1
+}
+
+func (i *Index) IndexDocument(doc *store.Document) error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	c := *doc
+	if n := i.find(doc.JournalUID, doc.ItemUID); n >= 0 {
+		i.docs[n] = &c
+		return nil
+	}
+	i.docs = append(i.docs, &c)
+	return nil
+}
+
+func (i *Index) RemoveDocument(j string, uid string) error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	if n := i.find(j, uid); n >= 0 {
+		i.docs = append(i.docs[:n:n], i.docs[n+1:]...)
+	}
+	return nil
+}
+
+func contains(list []string, s string) bool {
+	for _, l := range list {
+		if l == s {
+			return true
+		}
+	}
+	return false
+}
+
+func (i *Index) Search(query string, f store.SearchFilter) ([]*store.Document, error) {
+	var terms []term
+	for _, t := range strings.Fields(query) {
+		tokens := tokenize(t)
+		if len(tokens) > 0 {
+			// a term made of a single token matches as a prefix
+			prefix := len(tokens) == 1 && len(tokens[0]) == len(t)
+			terms = append(terms, term{tokens: tokens, prefix: prefix})
+		}
+	}
+	if len(terms) == 0 {
+		return nil, nil
+	}
+
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+
+	var docs []*store.Document
+	for _, doc := range i.docs {
+		if f.Limit > 0 && len(docs) == f.Limit {
+			break
+		}
+		if len(f.JournalUIDs) > 0 && !contains(f.JournalUIDs, doc.JournalUID) {
+			continue
+		}
+		if len(f.Kinds) > 0 && !contains(f.Kinds, doc.Kind) {
+			continue
+		}
+
+		text := tokenize(doc.Text)
+		match := true
+		for _, t := range terms {
+			if !t.matches(text) {
+				match = false
+				break
+			}
+		}
+
+		if match {
+			c := *doc
+			docs = append(docs, &c)
+		}
+	}
+	return docs, nil
+}
+
+func (i *Index) CountDocuments() (int, error) {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+
+	return len(i.docs), nil
+}
+
+// tokenize splits s in lowercase alphanumeric tokens
+func tokenize(s string) []string {
+	return strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// term is a term of a query split in tokens
+type term struct {
+	tokens []string
+	prefix bool
+}
+
+// matches reports whether the tokens of a text contain the term, either as
+// a token prefix or as a phrase
+func (t term) matches(text []string) bool {
+	for i := 0; i+len(t.tokens) <= len(text); i++ {
+		if t.prefix && strings.HasPrefix(text[i], t.tokens[0]) {
+			return true
+		}
+
+		match := true
+		for n, token := range t.tokens {
+			if text[i+n] != token {
+				match = false
+				break
+			}
+		}
+		if match {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,569 @@
+// Package memory implements a goroutine safe, in memory store.Store, useful
+// for tests and ephemeral sessions.
+package memory
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gchaincl/go-etesync/api"
+	"github.com/gchaincl/go-etesync/store"
+)
+
+var _ store.Store = &Store{}
+
+// state holds the data of a Store.
+// The stored values are copies never modified in place, so cloning the
+// containers is enough to snapshot it.
+type state struct {
+	journals  api.Journals
+	entries   map[string][]*entry
+	items     map[string]store.Items
+	outbox    store.Changes
+	lastID    uint64
+	conflicts store.Conflicts
+	sync      map[string]*store.SyncState
+}
+
+func newState() *state {
+	return &state{
+		entries: make(map[string][]*entry),
+		items:   make(map[string]store.Items),
+		sync:    make(map[string]*store.SyncState),
+	}
+}
+
+func (st *state) clone() *state {
+	n := newState()
+	n.journals = append(n.journals, st.journals...)
+	for k, v := range st.entries {
+		n.entries[k] = append([]*entry(nil), v...)
+	}
+	for k, v := range st.items {
+		n.items[k] = append(store.Items(nil), v...)
+	}
+	n.outbox = append(n.outbox, st.outbox...)
+	n.lastID = st.lastID
+	n.conflicts = append(n.conflicts, st.conflicts...)
+	for k, v := range st.sync {
+		n.sync[k] = v
+	}
+	return n
+}
+
+// accounts holds the state of every account, shared by the stores returned
+// by ForAccount
+type accounts struct {
+	states map[string]*state
+}
+
+func (a *accounts) clone() *accounts {
+	n := &accounts{states: make(map[string]*state, len(a.states))}
+	for k, v := range a.states {
+		n.states[k] = v.clone()
+	}
+	return n
+}
+
+type Store struct {
+	mu       *sync.RWMutex
+	accounts *accounts
+	account  string
+	tx       bool
+}
+
+func NewStore() *Store {
+	return &Store{
+		mu:       &sync.RWMutex{},
+		accounts: &accounts{states: make(map[string]*state)},
+	}
+}
+
+func (s *Store) ForAccount(account string) store.Store {
+	return &Store{mu: s.mu, accounts: s.accounts, account: account, tx: s.tx}
+}
+
+func (s *Store) Accounts() ([]string, error) {
+	var accounts []string
+	err := s.read(func(*state) error {
+		for account, st := range s.accounts.states {
+			if len(st.journals) > 0 {
+				accounts = append(accounts, account)
+			}
+		}
+		return nil
+	})
+
+	sort.Strings(accounts)
+	return accounts, err
+}
+
+// read calls fn holding a read lock, unless s is bound to a transaction
+func (s *Store) read(fn func(*state) error) error {
+	if !s.tx {
+		s.mu.RLock()
+		defer s.mu.RUnlock()
+	}
+
+	st, ok := s.accounts.states[s.account]
+	if !ok {
+		st = newState()
+	}
+	return fn(st)
+}
+
+// write calls fn holding the lock, unless s is bound to a transaction
+func (s *Store) write(fn func(*state) error) error {
+	if !s.tx {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+	}
+
+	st, ok := s.accounts.states[s.account]
+	if !ok {
+		st = newState()
+		s.accounts.states[s.account] = st
+	}
+	return fn(st)
+}
+
+// WithTx runs fn on a copy of the state which replaces the current one if fn
+// succeeds. Other calls are blocked until the transaction finishes.
+func (s *Store) WithTx(fn func(store.Store) error) error {
+	if s.tx {
+		return fn(s)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tx := &Store{mu: s.mu, accounts: s.accounts.clone(), account: s.account, tx: true}
+	if err := fn(tx); err != nil {
+		return err
+	}
+
+	s.accounts.states = tx.accounts.states
+	return nil
+}
+
+// entry is a stored api.Entry, storedAt is used by CompactEntries
+type entry struct {
+	entry    *api.Entry
+	storedAt time.Time
+}
+
+func copyEntry(e *api.Entry) *api.Entry {
+	c := *e
+	return &c
+}
+
+func copyJournal(j *api.Journal) *api.Journal {
+	c := *j
+	return &c
+}
+
+func copyItem(i *store.Item) *store.Item {
+	return &store.Item{UID: i.UID, Entry: copyEntry(i.Entry)}
+}
+
+func copyChange(c *store.Change) *store.Change {
+	n := *c
+	n.Entry = copyEntry(c.Entry)
+	return &n
+}
+
+func copyConflict(c *store.Conflict) *store.Conflict {
+	n := *c
+	n.Local = copyEntry(c.Local)
+	n.Remote = copyEntry(c.Remote)
+	return &n
+}
+
+// entryIndex returns the position of an entry or -1
+func (st *state) entryIndex(j string, uid string) int {
+	for i, e := range st.entries[j] {
+		if e.entry.UID == uid {
+			return i
+		}
+	}
+	return -1
+}
+
+func (s *Store) CreateEntry(j string, e *api.Entry) error {
+	return s.write(func(st *state) error {
+		st.entries[j] = append(st.entries[j], &entry{entry: copyEntry(e), storedAt: time.Now()})
+		return nil
+	})
+}
+
+func (s *Store) GetEntry(j string, uid string) (*api.Entry, error) {
+	var e *api.Entry
+	err := s.read(func(st *state) error {
+		i := st.entryIndex(j, uid)
+		if i < 0 {
+			return store.ErrRecordNotFound
+		}
+		e = copyEntry(st.entries[j][i].entry)
+		return nil
+	})
+	return e, err
+}
+
+func (s *Store) GetEntries(j string) (api.Entries, error) {
+	return s.EntriesAfter(j, "", 0)
+}
+
+func (s *Store) EntriesAfter(j string, uid string, limit int) (api.Entries, error) {
+	var entries api.Entries
+	err := s.read(func(st *state) error {
+		from := 0
+		if uid != "" {
+			i := st.entryIndex(j, uid)
+			if i < 0 {
+				return store.ErrRecordNotFound
+			}
+			from = i + 1
+		}
+
+		for _, e := range st.entries[j][from:] {
+			if limit > 0 && len(entries) == limit {
+				break
+			}
+			entries = append(entries, copyEntry(e.entry))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+func (s *Store) CountEntries(j string) (int, error) {
+	var count int
+	err := s.read(func(st *state) error {
+		count = len(st.entries[j])
+		return nil
+	})
+	return count, err
+}
+
+func (s *Store) CompactEntries(j string, r store.Retention) (int, error) {
+	keep := r.Count
+	if keep < 1 {
+		keep = 1
+	}
+
+	var deleted int
+	err := s.write(func(st *state) error {
+		es := st.entries[j]
+		if len(es) <= keep {
+			return nil
+		}
+
+		compacted := make([]*entry, 0, keep)
+		for i, e := range es {
+			if i < len(es)-keep && (r.Since.IsZero() || e.storedAt.Before(r.Since)) {
+				deleted++
+				continue
+			}
+			compacted = append(compacted, e)
+		}
+		st.entries[j] = compacted
+		return nil
+	})
+	return deleted, err
+}
+
+func (s *Store) LastEntry(j string) (*api.Entry, error) {
+	var e *api.Entry
+	err := s.read(func(st *state) error {
+		es := st.entries[j]
+		if len(es) == 0 {
+			return store.ErrRecordNotFound
+		}
+		e = copyEntry(es[len(es)-1].entry)
+		return nil
+	})
+	return e, err
+}
+
+// journalIndex returns the position of a journal or -1
+func (st *state) journalIndex(uid string) int {
+	for i, j := range st.journals {
+		if j.UID == uid {
+			return i
+		}
+	}
+	return -1
+}
+
+func (s *Store) CreateJournal(j *api.Journal) error {
+	return s.write(func(st *state) error {
+		st.journals = append(st.journals, copyJournal(j))
+		return nil
+	})
+}
+
+func (s *Store) UpdateJournal(j *api.Journal) error {
+	return s.write(func(st *state) error {
+		i := st.journalIndex(j.UID)
+		if i < 0 {
+			return store.ErrRecordNotFound
+		}
+		st.journals[i] = copyJournal(j)
+		return nil
+	})
+}
+
+func (s *Store) GetJournal(uid string) (*api.Journal, error) {
+	var j *api.Journal
+	err := s.read(func(st *state) error {
+		i := st.journalIndex(uid)
+		if i < 0 {
+			return store.ErrRecordNotFound
+		}
+		j = copyJournal(st.journals[i])
+		return nil
+	})
+	return j, err
+}
+
+func (s *Store) GetJournals() (api.Journals, error) {
+	var journals api.Journals
+	err := s.read(func(st *state) error {
+		for _, j := range st.journals {
+			journals = append(journals, copyJournal(j))
+		}
+		return nil
+	})
+	return journals, err
+}
+
+func (s *Store) DeleteJournal(uid string) error {
+	return s.write(func(st *state) error {
+		i := st.journalIndex(uid)
+		if i < 0 {
+			return store.ErrRecordNotFound
+		}
+		st.journals = append(st.journals[:i:i], st.journals[i+1:]...)
+		return nil
+	})
+}
+
+func (s *Store) PurgeJournal(uid string) error {
+	return s.write(func(st *state) error {
+		if i := st.journalIndex(uid); i >= 0 {
+			st.journals = append(st.journals[:i:i], st.journals[i+1:]...)
+		}
+		delete(st.entries, uid)
+		delete(st.items, uid)
+		delete(st.sync, uid)
+
+		var outbox store.Changes
+		for _, c := range st.outbox {
+			if c.JournalUID != uid {
+				outbox = append(outbox, c)
+			}
+		}
+		st.outbox = outbox
+
+		var conflicts store.Conflicts
+		for _, c := range st.conflicts {
+			if c.JournalUID != uid {
+				conflicts = append(conflicts, c)
+			}
+		}
+		st.conflicts = conflicts
+		return nil
+	})
+}
+
+// itemIndex returns the position of an item or -1
+func (st *state) itemIndex(j string, uid string) int {
+	for i, item := range st.items[j] {
+		if item.UID == uid {
+			return i
+		}
+	}
+	return -1
+}
+
+func (s *Store) PutItem(j string, item *store.Item) error {
+	return s.write(func(st *state) error {
+		if i := st.itemIndex(j, item.UID); i >= 0 {
+			st.items[j][i] = copyItem(item)
+			return nil
+		}
+		st.items[j] = append(st.items[j], copyItem(item))
+		return nil
+	})
+}
+
+func (s *Store) DeleteItem(j string, uid string) error {
+	return s.write(func(st *state) error {
+		i := st.itemIndex(j, uid)
+		if i < 0 {
+			return store.ErrRecordNotFound
+		}
+		items := st.items[j]
+		st.items[j] = append(items[:i:i], items[i+1:]...)
+		return nil
+	})
+}
+
+func (s *Store) Items(j string) (store.Items, error) {
+	var items store.Items
+	err := s.read(func(st *state) error {
+		for _, item := range st.items[j] {
+			items = append(items, copyItem(item))
+		}
+		return nil
+	})
+	return items, err
+}
+
+func (s *Store) Item(j string, uid string) (*store.Item, error) {
+	var item *store.Item
+	err := s.read(func(st *state) error {
+		i := st.itemIndex(j, uid)
+		if i < 0 {
+			return store.ErrRecordNotFound
+		}
+		item = copyItem(st.items[j][i])
+		return nil
+	})
+	return item, err
+}
+
+// changeIndex returns the position of a change or -1
+func (st *state) changeIndex(id uint64) int {
+	for i, c := range st.outbox {
+		if c.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+func (s *Store) EnqueueChange(c *store.Change) error {
+	return s.write(func(st *state) error {
+		st.lastID++
+		c.ID = st.lastID
+		st.outbox = append(st.outbox, copyChange(c))
+		return nil
+	})
+}
+
+func (s *Store) PendingChanges(j string) (store.Changes, error) {
+	var changes store.Changes
+	err := s.read(func(st *state) error {
+		for _, c := range st.outbox {
+			if c.SentAt.IsZero() && (j == "" || c.JournalUID == j) {
+				changes = append(changes, copyChange(c))
+			}
+		}
+		return nil
+	})
+	return changes, err
+}
+
+func (s *Store) MarkChangeSent(id uint64) error {
+	return s.write(func(st *state) error {
+		i := st.changeIndex(id)
+		if i < 0 {
+			return store.ErrRecordNotFound
+		}
+
+		c := copyChange(st.outbox[i])
+		c.SentAt = time.Now()
+		st.outbox[i] = c
+		return nil
+	})
+}
+
+func (s *Store) DiscardChange(id uint64) error {
+	return s.write(func(st *state) error {
+		i := st.changeIndex(id)
+		if i < 0 {
+			return store.ErrRecordNotFound
+		}
+		st.outbox = append(st.outbox[:i:i], st.outbox[i+1:]...)
+		return nil
+	})
+}
+
+func (s *Store) AddConflict(c *store.Conflict) error {
+	return s.write(func(st *state) error {
+		st.lastID++
+		c.ID = st.lastID
+		st.conflicts = append(st.conflicts, copyConflict(c))
+		return nil
+	})
+}
+
+func (s *Store) Conflicts(j string) (store.Conflicts, error) {
+	var conflicts store.Conflicts
+	err := s.read(func(st *state) error {
+		for _, c := range st.conflicts {
+			if j == "" || c.JournalUID == j {
+				conflicts = append(conflicts, copyConflict(c))
+			}
+		}
+		return nil
+	})
+	return conflicts, err
+}
+
+func (s *Store) DeleteConflict(id uint64) error {
+	return s.write(func(st *state) error {
+		for i, c := range st.conflicts {
+			if c.ID == id {
+				st.conflicts = append(st.conflicts[:i:i], st.conflicts[i+1:]...)
+				return nil
+			}
+		}
+		return store.ErrRecordNotFound
+	})
+}
+
+func copySyncState(st *store.SyncState) *store.SyncState {
+	c := *st
+	return &c
+}
+
+func (s *Store) PutSyncState(st *store.SyncState) error {
+	return s.write(func(state *state) error {
+		state.sync[st.JournalUID] = copySyncState(st)
+		return nil
+	})
+}
+
+func (s *Store) SyncState(j string) (*store.SyncState, error) {
+	var st *store.SyncState
+	err := s.read(func(state *state) error {
+		found, ok := state.sync[j]
+		if !ok {
+			return store.ErrRecordNotFound
+		}
+		st = copySyncState(found)
+		return nil
+	})
+	return st, err
+}
+
+func (s *Store) SyncStates() (store.SyncStates, error) {
+	var states store.SyncStates
+	err := s.read(func(state *state) error {
+		for _, st := range state.sync {
+			states = append(states, copySyncState(st))
+		}
+		return nil
+	})
+
+	sort.Slice(states, func(i, j int) bool {
+		return states[i].JournalUID < states[j].JournalUID
+	})
+	return states, err
+}
@@ -0,0 +1,54 @@
+package memory
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/gchaincl/go-etesync/api"
+	"github.com/gchaincl/go-etesync/store"
+	"github.com/gchaincl/go-etesync/store/storetest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemory(t *testing.T) {
+	f := func(t *testing.T) (store.Store, func()) {
+		return NewStore(), func() {}
+	}
+	storetest.TestSuite(t, f)
+}
+
+func TestConcurrentWrites(t *testing.T) {
+	s := NewStore()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for n := 0; n < 10; n++ {
+				e := &api.Entry{UID: fmt.Sprintf("%d-%d", i, n)}
+				err := s.WithTx(func(tx store.Store) error {
+					return tx.CreateEntry("parent", e)
+				})
+				assert.NoError(t, err)
+
+				_, err = s.GetEntries("parent")
+				assert.NoError(t, err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	count, err := s.CountEntries("parent")
+	require.NoError(t, err)
+	assert.Equal(t, 100, count)
+}
+
+func TestIndex(t *testing.T) {
+	f := func(t *testing.T) (store.Index, func()) {
+		return NewIndex(), func() {}
+	}
+	storetest.TestIndexSuite(t, f)
+}
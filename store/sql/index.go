@@ -0,0 +1,159 @@
+package sql
+
+import (
+	"errors"
+	"strings"
+	"unicode"
+
+	"github.com/gchaincl/go-etesync/store"
+	"github.com/jinzhu/gorm"
+)
+
+var (
+	// ErrIndexNotSupported is returned by NewIndex for non sqlite databases
+	ErrIndexNotSupported = errors.New("search index is only supported on sqlite3")
+)
+
+// Index implements store.Index on a sqlite FTS4 table, FTS4 is built by
+// go-sqlite3 by default while FTS5 requires the sqlite_fts5 build tag.
+type Index struct {
+	db      *gorm.DB
+	account string
+}
+
+var _ store.Index = &Index{}
+
+// document is a row of the search table
+type document struct {
+	Account    string
+	JournalUID string
+	ItemUID    string
+	Kind       string
+	Text       string
+}
+
+func (document) TableName() string { return "search" }
+
+// NewIndex returns an Index of the Store account stored along its data,
+// creating its table if needed
+func NewIndex(s *Store) (*Index, error) {
+	if s.db.Dialect().GetName() != "sqlite3" {
+		return nil, ErrIndexNotSupported
+	}
+
+	// tables created before accounts were introduced lack the account column,
+	// as the index can be rebuilt they are dropped
+	if s.db.HasTable("search") && s.db.Exec("SELECT account FROM search LIMIT 0").Error != nil {
+		if err := s.db.Exec("DROP TABLE search").Error; err != nil {
+			return nil, err
+		}
+	}
+
+	err := s.db.Exec(`CREATE VIRTUAL TABLE IF NOT EXISTS search USING fts4(
+		account, journal_uid, item_uid, kind, text,
+		notindexed=account, notindexed=journal_uid, notindexed=item_uid, notindexed=kind
+	)`).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return &Index{db: s.db, account: s.account}, nil
+}
+
+// HasIndex reports whether the Store account has indexed documents, so an
+// index enabled once can be kept up to date
+func HasIndex(s *Store) (bool, error) {
+	if s.db.Dialect().GetName() != "sqlite3" || !s.db.HasTable("search") {
+		return false, nil
+	}
+
+	// tables created before accounts were introduced are rebuilt by NewIndex
+	if s.db.Exec("SELECT account FROM search LIMIT 0").Error != nil {
+		return true, nil
+	}
+
+	var uids []string
+	err := s.db.Model(&document{}).Where("account = ?", s.account).Limit(1).Pluck("item_uid", &uids).Error
+	return len(uids) > 0, err
+}
+
+func (i *Index) IndexDocument(doc *store.Document) error {
+	return i.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Exec("DELETE FROM search WHERE account = ? AND journal_uid = ? AND item_uid = ?",
+			i.account, doc.JournalUID, doc.ItemUID,
+		).Error
+		if err != nil {
+			return err
+		}
+
+		return tx.Exec("INSERT INTO search (account, journal_uid, item_uid, kind, text) VALUES (?, ?, ?, ?, ?)",
+			i.account, doc.JournalUID, doc.ItemUID, doc.Kind, doc.Text,
+		).Error
+	})
+}
+
+func (i *Index) RemoveDocument(j string, uid string) error {
+	return i.db.Exec("DELETE FROM search WHERE account = ? AND journal_uid = ? AND item_uid = ?", i.account, j, uid).Error
+}
+
+func (i *Index) Search(query string, f store.SearchFilter) ([]*store.Document, error) {
+	match := matchQuery(query)
+	if match == "" {
+		return nil, nil
+	}
+
+	db := i.db.Where("search MATCH ? AND account = ?", match, i.account).Order("rowid")
+	if len(f.JournalUIDs) > 0 {
+		db = db.Where("journal_uid IN (?)", f.JournalUIDs)
+	}
+	if len(f.Kinds) > 0 {
+		db = db.Where("kind IN (?)", f.Kinds)
+	}
+	if f.Limit > 0 {
+		db = db.Limit(f.Limit)
+	}
+
+	var rows []*document
+	if err := db.Find(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	docs := make([]*store.Document, len(rows))
+	for i, row := range rows {
+		docs[i] = &store.Document{
+			JournalUID: row.JournalUID,
+			ItemUID:    row.ItemUID,
+			Kind:       row.Kind,
+			Text:       row.Text,
+		}
+	}
+	return docs, nil
+}
+
+func (i *Index) CountDocuments() (int, error) {
+	var count int
+	err := i.db.Model(&document{}).Where("account = ?", i.account).Count(&count).Error
+	return count, err
+}
+
+// matchQuery turns every term of query into a FTS token so users don't need
+// to know the query syntax. Alphanumeric terms match as prefixes while the
+// others (eg. emails) match as phrases.
+func matchQuery(query string) string {
+	var terms []string
+	for _, t := range strings.Fields(strings.ToLower(query)) {
+		t = strings.Replace(t, `"`, "", -1)
+		switch {
+		case t == "":
+		case strings.IndexFunc(t, isNotAlnum) < 0:
+			terms = append(terms, t+"*")
+		default:
+			terms = append(terms, `"`+t+`"`)
+		}
+	}
+	return strings.Join(terms, " ")
+}
+
+func isNotAlnum(r rune) bool {
+	return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+}
@@ -0,0 +1,93 @@
+package sql
+
+import (
+	"testing"
+
+	"github.com/gchaincl/go-etesync/store"
+	"github.com/gchaincl/go-etesync/store/storetest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIndex(t *testing.T) {
+	f := func(t *testing.T) (store.Index, func()) {
+		s, err := NewStore("sqlite3", ":memory:")
+		require.NoError(t, err)
+
+		idx, err := NewIndex(s)
+		require.NoError(t, err)
+
+		return idx, func() { s.Close() }
+	}
+	storetest.TestIndexSuite(t, f)
+}
+
+func TestIndexAccounts(t *testing.T) {
+	s, err := NewStore("sqlite3", ":memory:")
+	require.NoError(t, err)
+	defer s.Close()
+
+	// a table created before accounts were introduced is replaced
+	require.NoError(t, s.db.Exec("CREATE VIRTUAL TABLE search USING fts4(journal_uid, item_uid, kind, text)").Error)
+
+	a, err := NewIndex(s.ForAccount("a").(*Store))
+	require.NoError(t, err)
+	b, err := NewIndex(s.ForAccount("b").(*Store))
+	require.NoError(t, err)
+
+	doc := &store.Document{JournalUID: "j1", ItemUID: "i1", Kind: "contact", Text: "John Doe"}
+	require.NoError(t, a.IndexDocument(doc))
+	require.NoError(t, b.IndexDocument(doc))
+	require.NoError(t, b.RemoveDocument("j1", "i1"))
+
+	docs, err := a.Search("john", store.SearchFilter{})
+	require.NoError(t, err)
+	assert.Len(t, docs, 1)
+
+	docs, err = b.Search("john", store.SearchFilter{})
+	require.NoError(t, err)
+	assert.Len(t, docs, 0)
+
+	count, err := b.CountDocuments()
+	require.NoError(t, err)
+	assert.Equal(t, 0, count)
+}
+
+func TestHasIndex(t *testing.T) {
+	s, err := NewStore("sqlite3", ":memory:")
+	require.NoError(t, err)
+	defer s.Close()
+
+	a := s.ForAccount("a").(*Store)
+	has, err := HasIndex(a)
+	require.NoError(t, err)
+	assert.False(t, has)
+
+	idx, err := NewIndex(a)
+	require.NoError(t, err)
+	require.NoError(t, idx.IndexDocument(&store.Document{JournalUID: "j1", ItemUID: "i1", Text: "John"}))
+
+	has, err = HasIndex(a)
+	require.NoError(t, err)
+	assert.True(t, has)
+
+	has, err = HasIndex(s.ForAccount("b").(*Store))
+	require.NoError(t, err)
+	assert.False(t, has)
+}
+
+func TestMatchQuery(t *testing.T) {
+	tests := []struct {
+		query, expected string
+	}{
+		{"john", "john*"},
+		{"John OR doe", "john* or* doe*"},
+		{"john@work.com", `"john@work.com"`},
+		{`"quoted"`, "quoted*"},
+		{"  ", ""},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.expected, matchQuery(test.query))
+	}
+}
@@ -0,0 +1,260 @@
+package sql
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// migration upgrades the schema from version-1 to version.
+// Migrations are append only, once released they must not be modified.
+type migration struct {
+	version     int
+	description string
+	up          func(tx *gorm.DB) error
+}
+
+var migrations = []migration{
+	{1, "create entries, journals and items", createTables},
+	{2, "index entries by journal and uid", indexEntries},
+	{3, "create outbox", createOutbox},
+	{4, "create sync states", createSyncStates},
+	{5, "add removed to sync states", addSyncStateRemoved},
+	{6, "namespace tables by account", addAccounts},
+	{7, "add created_at to entries", addEntryCreatedAt},
+	{8, "create conflicts", createConflicts},
+	{9, "add invalid to sync states", addSyncStateInvalid},
+	{10, "add compacted before to sync states", addSyncStateCompactedBefore},
+}
+
+// schemaVersion records every applied migration
+type schemaVersion struct {
+	Version     int `gorm:"primary_key;auto_increment:false"`
+	Description string
+	AppliedAt   time.Time
+}
+
+func (schemaVersion) TableName() string { return "schema_version" }
+
+// Migrate applies the pending migrations, each one within a transaction
+func (s *Store) Migrate() error {
+	if !s.db.HasTable(&schemaVersion{}) {
+		if err := s.db.CreateTable(&schemaVersion{}).Error; err != nil {
+			return err
+		}
+	}
+
+	current, err := s.SchemaVersion()
+	if err != nil {
+		return err
+	}
+
+	for _, m := range migrations {
+		if m.version <= current {
+			continue
+		}
+
+		err := s.db.Transaction(func(tx *gorm.DB) error {
+			if err := m.up(tx); err != nil {
+				return err
+			}
+
+			return tx.Create(&schemaVersion{
+				Version:     m.version,
+				Description: m.description,
+				AppliedAt:   time.Now(),
+			}).Error
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// SchemaVersion returns the version of the last applied migration
+func (s *Store) SchemaVersion() (int, error) {
+	var v schemaVersion
+	db := s.db.Order("version desc").First(&v)
+	if db.RecordNotFound() {
+		return 0, nil
+	}
+	return v.Version, db.Error
+}
+
+// Tables as created by migration 1, databases created before the versioned
+// migrations already have them.
+
+type entryV1 struct {
+	ID         uint   `gorm:"primary_key"`
+	JournalUID string `gorm:"index:journal_uid;not null"`
+	UID        string
+	Content    string `gorm:"type:text"`
+}
+
+func (entryV1) TableName() string { return "entries" }
+
+type journalV1 struct {
+	ID       uint `gorm:"primary_key"`
+	Version  int
+	UID      string `gorm:"unique_index:journal_uid_unique"`
+	Content  string `gorm:"type:text"`
+	Owner    string
+	Key      string `gorm:"type:text"`
+	ReadOnly bool
+}
+
+func (journalV1) TableName() string { return "journals" }
+
+type itemV1 struct {
+	ID         uint   `gorm:"primary_key"`
+	JournalUID string `gorm:"unique_index:item_uid;not null"`
+	UID        string `gorm:"unique_index:item_uid;not null"`
+	EntryUID   string
+	Content    string `gorm:"type:text"`
+}
+
+func (itemV1) TableName() string { return "items" }
+
+func createTables(tx *gorm.DB) error {
+	for _, model := range []interface{}{&entryV1{}, &journalV1{}, &itemV1{}} {
+		if tx.HasTable(model) {
+			continue
+		}
+
+		if err := tx.CreateTable(model).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func indexEntries(tx *gorm.DB) error {
+	return tx.Model(&entryV1{}).AddIndex("entry_journal_uid_uid", "journal_uid", "uid").Error
+}
+
+type changeV3 struct {
+	ID         uint   `gorm:"primary_key"`
+	JournalUID string `gorm:"index:outbox_journal_uid;not null"`
+	EntryUID   string
+	Content    string `gorm:"type:text"`
+	CreatedAt  time.Time
+	SentAt     *time.Time
+}
+
+func (changeV3) TableName() string { return "outbox" }
+
+func createOutbox(tx *gorm.DB) error {
+	return tx.CreateTable(&changeV3{}).Error
+}
+
+type syncStateV4 struct {
+	ID           uint   `gorm:"primary_key"`
+	JournalUID   string `gorm:"unique_index:sync_state_journal_uid;not null"`
+	LastAttempt  time.Time
+	LastSuccess  *time.Time
+	LastEntryUID string
+	Entries      int
+	Error        string `gorm:"type:text"`
+	Duration     int64
+}
+
+func (syncStateV4) TableName() string { return "sync_states" }
+
+func createSyncStates(tx *gorm.DB) error {
+	return tx.CreateTable(&syncStateV4{}).Error
+}
+
+type syncStateV5 struct {
+	syncStateV4
+	Removed *time.Time
+}
+
+// addSyncStateRemoved adds the removed column, AutoMigrate only adds the
+// missing columns
+func addSyncStateRemoved(tx *gorm.DB) error {
+	return tx.AutoMigrate(&syncStateV5{}).Error
+}
+
+// addAccounts adds the account column to every table, existing rows belong
+// to the default empty account. The unique indexes are recreated to include
+// the account.
+func addAccounts(tx *gorm.DB) error {
+	for _, table := range []string{"entries", "journals", "items", "outbox", "sync_states"} {
+		err := tx.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN account varchar(255) NOT NULL DEFAULT ''", table)).Error
+		if err != nil {
+			return err
+		}
+	}
+
+	indexes := []struct {
+		model   interface{}
+		old     string
+		name    string
+		unique  bool
+		columns []string
+	}{
+		{&entryV1{}, "entry_journal_uid_uid", "entry_account_journal_uid_uid", false, []string{"account", "journal_uid", "uid"}},
+		{&journalV1{}, "journal_uid_unique", "journal_account_uid_unique", true, []string{"account", "uid"}},
+		{&itemV1{}, "item_uid", "item_account_uid", true, []string{"account", "journal_uid", "uid"}},
+		{&syncStateV4{}, "sync_state_journal_uid", "sync_state_account_journal_uid", true, []string{"account", "journal_uid"}},
+	}
+	for _, idx := range indexes {
+		db := tx.Model(idx.model)
+		if err := db.RemoveIndex(idx.old).Error; err != nil {
+			return err
+		}
+
+		if idx.unique {
+			db = db.AddUniqueIndex(idx.name, idx.columns...)
+		} else {
+			db = db.AddIndex(idx.name, idx.columns...)
+		}
+		if err := db.Error; err != nil {
+			return err
+		}
+	}
+	return tx.Model(&changeV3{}).AddIndex("outbox_account", "account").Error
+}
+
+type entryV7 struct {
+	entryV1
+	Account   string
+	CreatedAt *time.Time
+}
+
+func addEntryCreatedAt(tx *gorm.DB) error {
+	return tx.AutoMigrate(&entryV7{}).Error
+}
+
+type conflictV8 struct {
+	ID            uint   `gorm:"primary_key"`
+	Account       string `gorm:"index:conflict_account_journal_uid;not null"`
+	JournalUID    string `gorm:"index:conflict_account_journal_uid;not null"`
+	ItemUID       string
+	LocalUID      string
+	LocalContent  string `gorm:"type:text"`
+	RemoteUID     string
+	RemoteContent string `gorm:"type:text"`
+	CreatedAt     time.Time
+}
+
+func (conflictV8) TableName() string { return "conflicts" }
+
+func createConflicts(tx *gorm.DB) error {
+	return tx.CreateTable(&conflictV8{}).Error
+}
+
+// addSyncStateInvalid adds the invalid column, AutoMigrate would add back the
+// unique index on journal_uid which addAccounts removed
+func addSyncStateInvalid(tx *gorm.DB) error {
+	return tx.Exec("ALTER TABLE sync_states ADD COLUMN invalid integer NOT NULL DEFAULT 0").Error
+}
+
+// addSyncStateCompactedBefore adds the compacted_before column, see
+// addSyncStateInvalid
+func addSyncStateCompactedBefore(tx *gorm.DB) error {
+	return tx.Exec("ALTER TABLE sync_states ADD COLUMN compacted_before varchar(255) NOT NULL DEFAULT ''").Error
+}
@@ -0,0 +1,79 @@
+package sql
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gchaincl/go-etesync/api"
+	"github.com/gchaincl/go-etesync/store"
+	"github.com/jinzhu/gorm"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// legacySchema is the schema created by AutoMigrate before the versioned
+// migrations were introduced
+const legacySchema = `
+CREATE TABLE "entries" ("id" integer primary key autoincrement,"journal_uid" varchar(255) NOT NULL,"uid" varchar(255),"content" varchar(255));
+CREATE INDEX journal_uid ON "entries"(journal_uid);
+INSERT INTO "entries" ("journal_uid","uid","content") VALUES ('j1','e1','c1'), ('j1','e2','c2');
+`
+
+func TestMigrateUpgrade(t *testing.T) {
+	dir, err := ioutil.TempDir("", "etesync-sql")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "legacy.db")
+	legacy, err := gorm.Open("sqlite3", path)
+	require.NoError(t, err)
+	require.NoError(t, legacy.Exec(legacySchema).Error)
+	require.NoError(t, legacy.Close())
+
+	s, err := NewStore("sqlite3", path)
+	require.NoError(t, err)
+	defer s.Close()
+
+	require.NoError(t, s.Migrate())
+
+	v, err := s.SchemaVersion()
+	require.NoError(t, err)
+	assert.Equal(t, migrations[len(migrations)-1].version, v)
+
+	// existing entries are kept
+	es, err := s.GetEntries("j1")
+	require.NoError(t, err)
+	assert.Equal(t, api.Entries{
+		&api.Entry{UID: "e1", Content: "c1"},
+		&api.Entry{UID: "e2", Content: "c2"},
+	}, es)
+
+	// new tables are created
+	require.NoError(t, s.CreateJournal(&api.Journal{UID: "j1"}))
+	_, err = s.GetJournal("j1")
+	require.NoError(t, err)
+
+	t.Run("compacts legacy entries", func(t *testing.T) {
+		// legacy entries don't have the time they were stored
+		n, err := s.CompactEntries("j1", store.Retention{Since: time.Now().Add(-time.Hour)})
+		require.NoError(t, err)
+		assert.Equal(t, 1, n)
+	})
+
+	t.Run("is idempotent", func(t *testing.T) {
+		require.NoError(t, s.Migrate())
+
+		var count int
+		require.NoError(t, s.db.Model(&schemaVersion{}).Count(&count).Error)
+		assert.Equal(t, len(migrations), count)
+	})
+}
+
+func TestMigrationsAreSorted(t *testing.T) {
+	for i, m := range migrations {
+		assert.Equal(t, i+1, m.version)
+	}
+}
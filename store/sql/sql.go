@@ -1,34 +1,55 @@
 package sql
 
 import (
+	"time"
+
 	"github.com/gchaincl/go-etesync/api"
 	"github.com/gchaincl/go-etesync/store"
 	"github.com/jinzhu/gorm"
 )
 
 type Store struct {
-	db *gorm.DB
+	db      *gorm.DB
+	tx      bool
+	account string
 }
 
 func NewStore(driver, dsn string) (*Store, error) {
 	db, err := gorm.Open(driver, dsn)
-	return &Store{db: db}, err
-}
+	if err != nil {
+		return nil, err
+	}
 
-func (s *Store) Migrate() error {
-	err := s.db.AutoMigrate(
-		&Entry{},
-	).Error
+	if driver == "sqlite3" {
+		// sqlite allows a single writer, and every connection to ":memory:"
+		// opens a different database
+		db.DB().SetMaxOpenConns(1)
+	}
 
-	return err
+	return &Store{db: db}, nil
 }
 
 func (s *Store) Close() {
 	s.db.Close()
 }
 
-func (s *Store) first(where string, val interface{}, model interface{}) error {
-	db := s.db.Where(where, val).First(model)
+// scoped returns the db restricted to the rows of the store account
+func (s *Store) scoped() *gorm.DB {
+	return s.db.Where("account = ?", s.account)
+}
+
+func (s *Store) ForAccount(account string) store.Store {
+	return &Store{db: s.db, tx: s.tx, account: account}
+}
+
+func (s *Store) Accounts() ([]string, error) {
+	var accounts []string
+	err := s.db.Model(&Journal{}).Order("account").Pluck("DISTINCT account", &accounts).Error
+	return accounts, err
+}
+
+func (s *Store) first(model interface{}, where string, vals ...interface{}) error {
+	db := s.scoped().Where(where, vals...).First(model)
 	if db.RecordNotFound() {
 		return store.ErrRecordNotFound
 	}
@@ -42,35 +63,80 @@ func (s *Store) first(where string, val interface{}, model interface{}) error {
 
 func (s *Store) CreateEntry(j string, e *api.Entry) error {
 	return s.db.Create(
-		&Entry{JournalUID: j, Entry: e},
+		&Entry{Account: s.account, JournalUID: j, Entry: e},
 	).Error
 }
 
 func (s *Store) GetEntry(j string, uid string) (*api.Entry, error) {
 	var e = &api.Entry{}
-	if err := s.first("uid = ?", uid, e); err != nil {
+	if err := s.first(e, "journal_uid = ? AND uid = ?", j, uid); err != nil {
 		return nil, err
 	}
 	return e, nil
 }
 
 func (s *Store) GetEntries(j string) (api.Entries, error) {
-	var entries api.Entries
-	db := s.db.Find(&entries, "journal_uid = ?", j)
-	if db.RecordNotFound() {
-		return nil, nil
+	return s.EntriesAfter(j, "", 0)
+}
+
+func (s *Store) EntriesAfter(j string, uid string, limit int) (api.Entries, error) {
+	db := s.scoped().Where("journal_uid = ?", j).Order("id")
+	if uid != "" {
+		var after Entry
+		if err := s.first(&after, "journal_uid = ? AND uid = ?", j, uid); err != nil {
+			return nil, err
+		}
+		db = db.Where("id > ?", after.ID)
 	}
 
-	if err := db.Error; err != nil {
+	if limit > 0 {
+		db = db.Limit(limit)
+	}
+
+	var entries api.Entries
+	if err := db.Find(&entries).Error; err != nil {
 		return nil, err
 	}
 
 	return entries, nil
 }
 
+func (s *Store) CountEntries(j string) (int, error) {
+	var count int
+	err := s.scoped().Model(&Entry{}).Where("journal_uid = ?", j).Count(&count).Error
+	return count, err
+}
+
+func (s *Store) CompactEntries(j string, r store.Retention) (int, error) {
+	keep := r.Count
+	if keep < 1 {
+		keep = 1
+	}
+
+	var ids []uint
+	err := s.scoped().Model(&Entry{}).Where("journal_uid = ?", j).
+		Order("id desc").Limit(keep).Pluck("id", &ids).Error
+	if err != nil {
+		return 0, err
+	}
+
+	if len(ids) < keep {
+		return 0, nil
+	}
+
+	// entries stored before created_at was added have it NULL
+	db := s.scoped().Where("journal_uid = ? AND id < ?", j, ids[len(ids)-1])
+	if !r.Since.IsZero() {
+		db = db.Where("created_at IS NULL OR created_at < ?", r.Since)
+	}
+
+	db = db.Delete(&api.Entry{})
+	return int(db.RowsAffected), db.Error
+}
+
 func (s *Store) LastEntry(j string) (*api.Entry, error) {
 	var e Entry
-	db := s.db.Last(&e, "journal_uid = ?", j)
+	db := s.scoped().Last(&e, "journal_uid = ?", j)
 	if db.RecordNotFound() {
 		return nil, store.ErrRecordNotFound
 	}
@@ -81,3 +147,285 @@ func (s *Store) LastEntry(j string) (*api.Entry, error) {
 
 	return e.Entry, nil
 }
+
+func (s *Store) CreateJournal(j *api.Journal) error {
+	return s.db.Create(
+		&Journal{Account: s.account, Journal: j},
+	).Error
+}
+
+func (s *Store) UpdateJournal(j *api.Journal) error {
+	db := s.scoped().Model(&api.Journal{}).Where("uid = ?", j.UID).Updates(map[string]interface{}{
+		"version":   j.Version,
+		"content":   j.Content,
+		"owner":     j.Owner,
+		"key":       j.Key,
+		"read_only": j.ReadOnly,
+	})
+	if err := db.Error; err != nil {
+		return err
+	}
+
+	if db.RowsAffected == 0 {
+		return store.ErrRecordNotFound
+	}
+	return nil
+}
+
+func (s *Store) GetJournal(uid string) (*api.Journal, error) {
+	var j = &api.Journal{}
+	if err := s.first(j, "uid = ?", uid); err != nil {
+		return nil, err
+	}
+	return j, nil
+}
+
+func (s *Store) GetJournals() (api.Journals, error) {
+	var journals api.Journals
+	if err := s.scoped().Order("id").Find(&journals).Error; err != nil {
+		return nil, err
+	}
+
+	return journals, nil
+}
+
+func (s *Store) DeleteJournal(uid string) error {
+	db := s.scoped().Where("uid = ?", uid).Delete(&api.Journal{})
+	if err := db.Error; err != nil {
+		return err
+	}
+
+	if db.RowsAffected == 0 {
+		return store.ErrRecordNotFound
+	}
+	return nil
+}
+
+func (s *Store) PurgeJournal(uid string) error {
+	return s.WithTx(func(tx store.Store) error {
+		db := tx.(*Store).scoped()
+		for _, model := range []interface{}{&api.Entry{}, &Item{}, &Change{}, &Conflict{}, &SyncState{}} {
+			if err := db.Where("journal_uid = ?", uid).Delete(model).Error; err != nil {
+				return err
+			}
+		}
+		return db.Where("uid = ?", uid).Delete(&api.Journal{}).Error
+	})
+}
+
+func (s *Store) PutItem(j string, i *store.Item) error {
+	var item Item
+	err := s.scoped().Where("journal_uid = ? AND uid = ?", j, i.UID).First(&item).Error
+	if err != nil && !gorm.IsRecordNotFoundError(err) {
+		return err
+	}
+
+	item.Account = s.account
+	item.JournalUID = j
+	item.UID = i.UID
+	item.EntryUID = i.Entry.UID
+	item.Content = i.Entry.Content
+	return s.db.Save(&item).Error
+}
+
+func (s *Store) DeleteItem(j string, uid string) error {
+	db := s.scoped().Where("journal_uid = ? AND uid = ?", j, uid).Delete(&Item{})
+	if err := db.Error; err != nil {
+		return err
+	}
+
+	if db.RowsAffected == 0 {
+		return store.ErrRecordNotFound
+	}
+	return nil
+}
+
+func (s *Store) Items(j string) (store.Items, error) {
+	var rows []*Item
+	if err := s.scoped().Order("id").Find(&rows, "journal_uid = ?", j).Error; err != nil {
+		return nil, err
+	}
+
+	items := make(store.Items, len(rows))
+	for i, row := range rows {
+		items[i] = row.item()
+	}
+	return items, nil
+}
+
+func (s *Store) Item(j string, uid string) (*store.Item, error) {
+	var item Item
+	if err := s.first(&item, "journal_uid = ? AND uid = ?", j, uid); err != nil {
+		return nil, err
+	}
+
+	return item.item(), nil
+}
+
+func (s *Store) EnqueueChange(c *store.Change) error {
+	row := &Change{
+		Account:    s.account,
+		JournalUID: c.JournalUID,
+		EntryUID:   c.Entry.UID,
+		Content:    c.Entry.Content,
+		CreatedAt:  c.CreatedAt,
+	}
+	if err := s.db.Create(row).Error; err != nil {
+		return err
+	}
+
+	c.ID = uint64(row.ID)
+	return nil
+}
+
+func (s *Store) PendingChanges(j string) (store.Changes, error) {
+	db := s.scoped().Where("sent_at IS NULL").Order("id")
+	if j != "" {
+		db = db.Where("journal_uid = ?", j)
+	}
+
+	var rows []*Change
+	if err := db.Find(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	changes := make(store.Changes, len(rows))
+	for i, row := range rows {
+		changes[i] = row.change()
+	}
+	return changes, nil
+}
+
+func (s *Store) MarkChangeSent(id uint64) error {
+	db := s.scoped().Model(&Change{}).Where("id = ?", id).Update("sent_at", time.Now())
+	if err := db.Error; err != nil {
+		return err
+	}
+
+	if db.RowsAffected == 0 {
+		return store.ErrRecordNotFound
+	}
+	return nil
+}
+
+func (s *Store) DiscardChange(id uint64) error {
+	db := s.scoped().Where("id = ?", id).Delete(&Change{})
+	if err := db.Error; err != nil {
+		return err
+	}
+
+	if db.RowsAffected == 0 {
+		return store.ErrRecordNotFound
+	}
+	return nil
+}
+
+func (s *Store) AddConflict(c *store.Conflict) error {
+	row := &Conflict{
+		Account:       s.account,
+		JournalUID:    c.JournalUID,
+		ItemUID:       c.ItemUID,
+		LocalUID:      c.Local.UID,
+		LocalContent:  c.Local.Content,
+		RemoteUID:     c.Remote.UID,
+		RemoteContent: c.Remote.Content,
+		CreatedAt:     c.CreatedAt,
+	}
+	if err := s.db.Create(row).Error; err != nil {
+		return err
+	}
+
+	c.ID = uint64(row.ID)
+	return nil
+}
+
+func (s *Store) Conflicts(j string) (store.Conflicts, error) {
+	db := s.scoped().Order("id")
+	if j != "" {
+		db = db.Where("journal_uid = ?", j)
+	}
+
+	var rows []*Conflict
+	if err := db.Find(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	conflicts := make(store.Conflicts, len(rows))
+	for i, row := range rows {
+		conflicts[i] = row.conflict()
+	}
+	return conflicts, nil
+}
+
+func (s *Store) DeleteConflict(id uint64) error {
+	db := s.scoped().Where("id = ?", id).Delete(&Conflict{})
+	if err := db.Error; err != nil {
+		return err
+	}
+
+	if db.RowsAffected == 0 {
+		return store.ErrRecordNotFound
+	}
+	return nil
+}
+
+func (s *Store) WithTx(fn func(store.Store) error) error {
+	if s.tx {
+		return fn(s)
+	}
+
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		return fn(&Store{db: tx, tx: true, account: s.account})
+	})
+}
+
+func (s *Store) PutSyncState(st *store.SyncState) error {
+	var row SyncState
+	err := s.scoped().Where("journal_uid = ?", st.JournalUID).First(&row).Error
+	if err != nil && !gorm.IsRecordNotFoundError(err) {
+		return err
+	}
+
+	row.Account = s.account
+	row.JournalUID = st.JournalUID
+	row.LastAttempt = st.LastAttempt
+	row.LastSuccess = nil
+	if !st.LastSuccess.IsZero() {
+		t := st.LastSuccess
+		row.LastSuccess = &t
+	}
+	row.LastEntryUID = st.LastEntryUID
+	row.Entries = st.Entries
+	row.Invalid = st.Invalid
+	row.CompactedBefore = st.CompactedBefore
+	row.Error = st.Error
+	row.Duration = int64(st.Duration)
+	row.Removed = nil
+	if !st.Removed.IsZero() {
+		t := st.Removed
+		row.Removed = &t
+	}
+	return s.db.Save(&row).Error
+}
+
+func (s *Store) SyncState(j string) (*store.SyncState, error) {
+	var row SyncState
+	if err := s.first(&row, "journal_uid = ?", j); err != nil {
+		return nil, err
+	}
+
+	return row.syncState(), nil
+}
+
+func (s *Store) SyncStates() (store.SyncStates, error) {
+	var rows []*SyncState
+	if err := s.scoped().Order("journal_uid").Find(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	states := make(store.SyncStates, len(rows))
+	for i, row := range rows {
+		states[i] = row.syncState()
+	}
+	return states, nil
+}
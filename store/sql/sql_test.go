@@ -1,10 +1,12 @@
 package sql
 
 import (
+	"os"
 	"testing"
 
 	"github.com/gchaincl/go-etesync/store"
 	"github.com/gchaincl/go-etesync/store/storetest"
+	_ "github.com/jinzhu/gorm/dialects/postgres"
 	_ "github.com/mattn/go-sqlite3"
 	"github.com/stretchr/testify/require"
 )
@@ -20,3 +22,31 @@ func TestSQL(t *testing.T) {
 	}
 	storetest.TestSuite(t, f)
 }
+
+func TestPostgres(t *testing.T) {
+	dsn := os.Getenv("ETESYNC_TEST_POSTGRES")
+	if dsn == "" {
+		t.Skipf("ETESYNC_TEST_POSTGRES not declared")
+	}
+
+	f := func(t *testing.T) (store.Store, func()) {
+		s, err := NewStore("postgres", dsn)
+		require.NoError(t, err)
+		require.NoError(t, s.Migrate())
+
+		return s, func() {
+			dropTables(t, s)
+			s.Close()
+		}
+	}
+	storetest.TestSuite(t, f)
+}
+
+// dropTables drops every table created by the migrations
+func dropTables(t *testing.T, s *Store) {
+	err := s.db.DropTableIfExists(
+		&entryV1{}, &journalV1{}, &itemV1{}, &changeV3{}, &syncStateV4{},
+		&conflictV8{}, &schemaVersion{},
+	).Error
+	require.NoError(t, err)
+}
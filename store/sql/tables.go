@@ -1,9 +1,122 @@
 package sql
 
-import "github.com/gchaincl/go-etesync/api"
+import (
+	"time"
+
+	"github.com/gchaincl/go-etesync/api"
+	"github.com/gchaincl/go-etesync/store"
+)
 
 type Entry struct {
-	ID         uint   `gorm:"primary_key"`
+	ID         uint `gorm:"primary_key"`
+	Account    string
 	JournalUID string `gorm:"index:journal_uid;not null"`
+	CreatedAt  time.Time
 	*api.Entry
 }
+
+type Journal struct {
+	ID      uint `gorm:"primary_key"`
+	Account string
+	*api.Journal
+}
+
+type Item struct {
+	ID         uint `gorm:"primary_key"`
+	Account    string
+	JournalUID string `gorm:"unique_index:item_uid;not null"`
+	UID        string `gorm:"unique_index:item_uid;not null"`
+	EntryUID   string
+	Content    string
+}
+
+func (i *Item) item() *store.Item {
+	return &store.Item{
+		UID:   i.UID,
+		Entry: &api.Entry{UID: i.EntryUID, Content: i.Content},
+	}
+}
+
+type Change struct {
+	ID         uint `gorm:"primary_key"`
+	Account    string
+	JournalUID string
+	EntryUID   string
+	Content    string
+	CreatedAt  time.Time
+	SentAt     *time.Time
+}
+
+func (Change) TableName() string { return "outbox" }
+
+func (c *Change) change() *store.Change {
+	ch := &store.Change{
+		ID:         uint64(c.ID),
+		JournalUID: c.JournalUID,
+		Entry:      &api.Entry{UID: c.EntryUID, Content: c.Content},
+		CreatedAt:  c.CreatedAt,
+	}
+	if c.SentAt != nil {
+		ch.SentAt = *c.SentAt
+	}
+	return ch
+}
+
+type Conflict struct {
+	ID            uint `gorm:"primary_key"`
+	Account       string
+	JournalUID    string
+	ItemUID       string
+	LocalUID      string
+	LocalContent  string
+	RemoteUID     string
+	RemoteContent string
+	CreatedAt     time.Time
+}
+
+func (c *Conflict) conflict() *store.Conflict {
+	return &store.Conflict{
+		ID:         uint64(c.ID),
+		JournalUID: c.JournalUID,
+		ItemUID:    c.ItemUID,
+		Local:      &api.Entry{UID: c.LocalUID, Content: c.LocalContent},
+		Remote:     &api.Entry{UID: c.RemoteUID, Content: c.RemoteContent},
+		CreatedAt:  c.CreatedAt,
+	}
+}
+
+type SyncState struct {
+	ID           uint `gorm:"primary_key"`
+	Account      string
+	JournalUID   string
+	LastAttempt  time.Time
+	LastSuccess  *time.Time
+	LastEntryUID string
+	Entries      int
+	Invalid      int
+	// CompactedBefore is the first entry kept by the last compaction
+	CompactedBefore string
+	Error           string
+	Duration        int64
+	Removed         *time.Time
+}
+
+func (s *SyncState) syncState() *store.SyncState {
+	st := &store.SyncState{
+		JournalUID:      s.JournalUID,
+		LastAttempt:     s.LastAttempt,
+		LastEntryUID:    s.LastEntryUID,
+		Entries:         s.Entries,
+		Invalid:         s.Invalid,
+		CompactedBefore: s.CompactedBefore,
+		Error:           s.Error,
+		Duration:        time.Duration(s.Duration),
+	}
+	if s.LastSuccess != nil {
+		st.LastSuccess = *s.LastSuccess
+	}
+	if s.Removed != nil {
+		st.Removed = *s.Removed
+	}
+	return st
+}
@@ -2,6 +2,8 @@ package store
 
 import (
 	"errors"
+	"strings"
+	"time"
 
 	"github.com/gchaincl/go-etesync/api"
 )
@@ -10,9 +12,145 @@ var (
 	ErrRecordNotFound = errors.New("record not found")
 )
 
+// Store persists journals, entries and items.
+// Entries are always returned in the order they were created.
+// The data is namespaced by account, so several accounts can share a
+// database, a Store only sees the data of its account.
 type Store interface {
+	// ForAccount returns a Store bound to the data of account, sharing the
+	// database and transaction of this one. The empty account is the default
+	// one, used by databases created before accounts were introduced.
+	ForAccount(account string) Store
+	// Accounts returns the accounts with stored journals, sorted
+	Accounts() ([]string, error)
+
 	CreateEntry(journalUID string, entry *api.Entry) error
 	GetEntries(journalUID string) (api.Entries, error)
 	GetEntry(journalUID string, entryUID string) (*api.Entry, error)
 	LastEntry(journalUID string) (*api.Entry, error)
+	// EntriesAfter returns up to limit entries created after entryUID, or
+	// from the first one if entryUID is empty. A limit <= 0 means no limit.
+	EntriesAfter(journalUID string, entryUID string, limit int) (api.Entries, error)
+	CountEntries(journalUID string) (int, error)
+	// CompactEntries deletes the entries of a journal not kept by the
+	// retention, returning how many were deleted
+	CompactEntries(journalUID string, retention Retention) (int, error)
+
+	CreateJournal(journal *api.Journal) error
+	UpdateJournal(journal *api.Journal) error
+	GetJournal(uid string) (*api.Journal, error)
+	GetJournals() (api.Journals, error)
+	DeleteJournal(uid string) error
+	// PurgeJournal deletes a journal along with its entries, items, pending
+	// changes, conflicts and sync state. Missing data is not an error.
+	PurgeJournal(uid string) error
+
+	PutItem(journalUID string, item *Item) error
+	DeleteItem(journalUID string, itemUID string) error
+	Items(journalUID string) (Items, error)
+	Item(journalUID string, itemUID string) (*Item, error)
+
+	// EnqueueChange adds a change to the outbox setting its ID
+	EnqueueChange(change *Change) error
+	// PendingChanges returns the changes not yet sent in the order they were
+	// enqueued, for every journal if journalUID is empty
+	PendingChanges(journalUID string) (Changes, error)
+	MarkChangeSent(id uint64) error
+	DiscardChange(id uint64) error
+
+	// AddConflict records a conflict setting its ID
+	AddConflict(conflict *Conflict) error
+	// Conflicts returns the recorded conflicts in the order they were added,
+	// for every journal if journalUID is empty
+	Conflicts(journalUID string) (Conflicts, error)
+	DeleteConflict(id uint64) error
+
+	// PutSyncState creates or replaces the sync state of a journal
+	PutSyncState(state *SyncState) error
+	SyncState(journalUID string) (*SyncState, error)
+	// SyncStates returns the sync state of every journal sorted by JournalUID
+	SyncStates() (SyncStates, error)
+
+	// WithTx calls fn with a Store bound to a transaction, which is committed
+	// if fn returns nil and rolled back otherwise. Nested calls run within
+	// the outer transaction.
+	WithTx(fn func(Store) error) error
+}
+
+// Retention selects the entries kept by CompactEntries besides the last one,
+// which is always kept as it's needed to sync. An entry is kept if any of the
+// fields selects it, zero fields select none.
+// Items keep their content as they embed their last entry.
+type Retention struct {
+	// Count keeps the last Count entries
+	Count int
+	// Since keeps the entries stored since then
+	Since time.Time
+}
+
+// Item is the current state of an event, task or contact, UID is the
+// iCalendar/vCard UID and Entry is the last entry that added or changed it
+type Item struct {
+	UID   string
+	Entry *api.Entry
+}
+
+type Items []*Item
+
+// Change is an entry created locally, kept in the outbox until it's sent
+type Change struct {
+	ID         uint64
+	JournalUID string
+	Entry      *api.Entry
+	CreatedAt  time.Time
+	// SentAt is zero until the change is marked as sent
+	SentAt time.Time
+}
+
+type Changes []*Change
+
+// Conflict is a local change to an item which couldn't be reconciled with a
+// change from the server, it's kept until handled manually
+type Conflict struct {
+	ID         uint64
+	JournalUID string
+	ItemUID    string
+	// Local is the discarded local entry and Remote the one from the server
+	Local     *api.Entry
+	Remote    *api.Entry
+	CreatedAt time.Time
+}
+
+type Conflicts []*Conflict
+
+// SyncState is the outcome of the last sync of a journal
+type SyncState struct {
+	JournalUID  string
+	LastAttempt time.Time
+	// LastSuccess is zero until a sync succeeds
+	LastSuccess time.Time
+	// LastEntryUID is the last entry stored by a sync
+	LastEntryUID string
+	// Entries is the number of entries stored by the last attempt
+	Entries int
+	// Invalid is how many of them couldn't be decrypted or parsed, they're
+	// stored but don't change the items
+	Invalid int
+	// CompactedBefore is the first entry kept by the last compaction, the
+	// ones before it were deleted. Empty while the history is complete.
+	CompactedBefore string
+	// Error is the error of the last attempt, empty if it succeeded
+	Error    string
+	Duration time.Duration
+	// Removed is when the journal was found removed from the server, zero
+	// while it's available
+	Removed time.Time
+}
+
+type SyncStates []*SyncState
+
+// AccountID returns the account of a user on a server, eg.
+// https://api.etesync.com/me@example.com
+func AccountID(url, username string) string {
+	return strings.TrimRight(url, "/") + "/" + username
 }
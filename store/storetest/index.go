@@ -0,0 +1,142 @@
+package storetest
+
+import (
+	"testing"
+
+	"github.com/gchaincl/go-etesync/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type IndexFactory func(t *testing.T) (store.Index, func())
+
+func TestIndexSuite(t *testing.T, f IndexFactory) {
+	tests := []struct {
+		name string
+		run  func(*testing.T, store.Index)
+	}{
+		{"Index/Search", TestIndexSearch},
+		{"Index/Filter", TestIndexFilter},
+		{"Index/Replace", TestIndexReplace},
+		{"Index/Remove", TestIndexRemove},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			idx, cleanup := f(t)
+			defer cleanup()
+
+			test.run(t, idx)
+		})
+	}
+}
+
+var documents = []*store.Document{
+	{JournalUID: "contacts", ItemUID: "john", Kind: "contact", Text: "John Doe\njohn@work.com\n+1 555 1234\n15551234"},
+	{JournalUID: "contacts", ItemUID: "jane", Kind: "contact", Text: "Jane Roe\njane@home.com"},
+	{JournalUID: "calendar", ItemUID: "meeting", Kind: "event", Text: "Meeting with John\nRoom 1"},
+	{JournalUID: "tasks", ItemUID: "milk", Kind: "task", Text: "Buy milk"},
+}
+
+func indexDocuments(t *testing.T, idx store.Index) {
+	for _, doc := range documents {
+		require.NoError(t, idx.IndexDocument(doc))
+	}
+}
+
+func itemUIDs(docs []*store.Document) []string {
+	var uids []string
+	for _, doc := range docs {
+		uids = append(uids, doc.ItemUID)
+	}
+	return uids
+}
+
+func TestIndexSearch(t *testing.T, idx store.Index) {
+	indexDocuments(t, idx)
+
+	count, err := idx.CountDocuments()
+	require.NoError(t, err)
+	assert.Equal(t, len(documents), count)
+
+	tests := []struct {
+		query    string
+		expected []string
+	}{
+		{"john", []string{"john", "meeting"}},
+		{"JOHN doe", []string{"john"}},
+		{"john@work.com", []string{"john"}},
+		{"work.com", []string{"john"}},
+		{"com.work", nil},
+		{"15551234", []string{"john"}},
+		{"555", []string{"john"}},
+		{"mil", []string{"milk"}},
+		// terms match tokens, not substrings
+		{"ilk", nil},
+		{"milk.", []string{"milk"}},
+		{"mil.", nil},
+		{"milk", []string{"milk"}},
+		{"nothing", nil},
+		{"", nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.query, func(t *testing.T) {
+			docs, err := idx.Search(test.query, store.SearchFilter{})
+			require.NoError(t, err)
+			assert.Equal(t, test.expected, itemUIDs(docs))
+		})
+	}
+
+	t.Run("returns documents", func(t *testing.T) {
+		docs, err := idx.Search("milk", store.SearchFilter{})
+		require.NoError(t, err)
+		require.Len(t, docs, 1)
+		assert.Equal(t, documents[3], docs[0])
+	})
+}
+
+func TestIndexFilter(t *testing.T, idx store.Index) {
+	indexDocuments(t, idx)
+
+	docs, err := idx.Search("john", store.SearchFilter{JournalUIDs: []string{"calendar"}})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"meeting"}, itemUIDs(docs))
+
+	docs, err = idx.Search("john", store.SearchFilter{Kinds: []string{"contact"}})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"john"}, itemUIDs(docs))
+
+	docs, err = idx.Search("john", store.SearchFilter{Limit: 1})
+	require.NoError(t, err)
+	assert.Len(t, docs, 1)
+}
+
+func TestIndexReplace(t *testing.T, idx store.Index) {
+	indexDocuments(t, idx)
+
+	doc := &store.Document{JournalUID: "tasks", ItemUID: "milk", Kind: "task", Text: "Buy bread"}
+	require.NoError(t, idx.IndexDocument(doc))
+
+	docs, err := idx.Search("milk", store.SearchFilter{})
+	require.NoError(t, err)
+	assert.Len(t, docs, 0)
+
+	docs, err = idx.Search("bread", store.SearchFilter{})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"milk"}, itemUIDs(docs))
+
+	count, err := idx.CountDocuments()
+	require.NoError(t, err)
+	assert.Equal(t, len(documents), count)
+}
+
+func TestIndexRemove(t *testing.T, idx store.Index) {
+	indexDocuments(t, idx)
+
+	require.NoError(t, idx.RemoveDocument("contacts", "john"))
+
+	docs, err := idx.Search("john", store.SearchFilter{})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"meeting"}, itemUIDs(docs))
+}
@@ -1,8 +1,10 @@
 package storetest
 
 import (
+	"errors"
 	"fmt"
 	"testing"
+	"time"
 
 	"github.com/gchaincl/go-etesync/api"
 	"github.com/gchaincl/go-etesync/store"
@@ -21,6 +23,35 @@ func TestSuite(t *testing.T, f Factory) {
 		{"Entry/NotFound", TestEntryNotFound},
 		{"Entry/Last", TestLastEntry},
 		{"Entry/GetEntries", TestEntryGetEntries},
+		{"Entry/Scoped", TestEntryScoped},
+		{"Entry/EntriesAfter", TestEntriesAfter},
+		{"Entry/Count", TestEntryCount},
+		{"Entry/Compact", TestEntryCompact},
+		{"Journal/Create", TestJournalCreate},
+		{"Journal/NotFound", TestJournalNotFound},
+		{"Journal/Update", TestJournalUpdate},
+		{"Journal/GetJournals", TestJournalGetJournals},
+		{"Journal/Delete", TestJournalDelete},
+		{"Journal/Purge", TestJournalPurge},
+		{"Item/Put", TestItemPut},
+		{"Item/NotFound", TestItemNotFound},
+		{"Item/Delete", TestItemDelete},
+		{"Item/Items", TestItemItems},
+		{"Outbox/Enqueue", TestOutboxEnqueue},
+		{"Outbox/MarkSent", TestOutboxMarkSent},
+		{"Outbox/Discard", TestOutboxDiscard},
+		{"Outbox/NotFound", TestOutboxNotFound},
+		{"Conflict/Add", TestConflictAdd},
+		{"Conflict/Delete", TestConflictDelete},
+		{"SyncState/Put", TestSyncStatePut},
+		{"SyncState/NotFound", TestSyncStateNotFound},
+		{"SyncState/SyncStates", TestSyncStates},
+		{"Account/Isolation", TestAccountIsolation},
+		{"Account/Accounts", TestAccounts},
+		{"Account/Tx", TestAccountTx},
+		{"Tx/Commit", TestTxCommit},
+		{"Tx/Rollback", TestTxRollback},
+		{"Tx/Nested", TestTxNested},
 	}
 
 	for _, test := range tests {
@@ -84,7 +115,10 @@ func TestEntryGetEntries(t *testing.T, s store.Store) {
 	entries, err := s.GetEntries("uid-a")
 	require.NoError(t, err)
 
-	assert.Len(t, entries, total)
+	require.Len(t, entries, total)
+	for i, e := range entries {
+		assert.Equal(t, fmt.Sprintf("uid%d", i), e.UID)
+	}
 
 	t.Run("empty entries", func(t *testing.T) {
 		entries, err := s.GetEntries("uid-xxx")
@@ -92,3 +126,713 @@ func TestEntryGetEntries(t *testing.T, s store.Store) {
 		assert.Len(t, entries, 0)
 	})
 }
+
+func TestEntryScoped(t *testing.T, s store.Store) {
+	require.NoError(t, s.CreateEntry("uid-a", &api.Entry{UID: "abcd", Content: "a"}))
+	require.NoError(t, s.CreateEntry("uid-b", &api.Entry{UID: "abcd", Content: "b"}))
+
+	found, err := s.GetEntry("uid-b", "abcd")
+	require.NoError(t, err)
+	assert.Equal(t, "b", found.Content)
+
+	_, err = s.GetEntry("uid-c", "abcd")
+	assert.Equal(t, store.ErrRecordNotFound, err)
+}
+
+func TestEntriesAfter(t *testing.T, s store.Store) {
+	// UIDs are not sorted to make sure insertion order is used
+	uids := []string{"e", "d", "c", "b", "a"}
+	for _, uid := range uids {
+		require.NoError(t, s.CreateEntry("parent", &api.Entry{UID: uid}))
+		require.NoError(t, s.CreateEntry("other", &api.Entry{UID: uid + uid}))
+	}
+
+	uidsOf := func(es api.Entries) []string {
+		var uids []string
+		for _, e := range es {
+			uids = append(uids, e.UID)
+		}
+		return uids
+	}
+
+	tests := []struct {
+		after    string
+		limit    int
+		expected []string
+	}{
+		{"", 0, uids},
+		{"", 2, []string{"e", "d"}},
+		{"d", 0, []string{"c", "b", "a"}},
+		{"d", 2, []string{"c", "b"}},
+		{"b", 10, []string{"a"}},
+		{"a", 0, nil},
+	}
+
+	for _, test := range tests {
+		t.Run(fmt.Sprintf("after=%q,limit=%d", test.after, test.limit), func(t *testing.T) {
+			entries, err := s.EntriesAfter("parent", test.after, test.limit)
+			require.NoError(t, err)
+			assert.Equal(t, test.expected, uidsOf(entries))
+		})
+	}
+
+	t.Run("unknown entry", func(t *testing.T) {
+		_, err := s.EntriesAfter("parent", "ee", 0)
+		assert.Equal(t, store.ErrRecordNotFound, err)
+	})
+}
+
+func TestEntryCount(t *testing.T, s store.Store) {
+	count, err := s.CountEntries("parent")
+	require.NoError(t, err)
+	assert.Equal(t, 0, count)
+
+	for i := 0; i < 5; i++ {
+		require.NoError(t, s.CreateEntry("parent", &api.Entry{UID: fmt.Sprintf("uid%d", i)}))
+	}
+	require.NoError(t, s.CreateEntry("other", &api.Entry{UID: "uid"}))
+
+	count, err = s.CountEntries("parent")
+	require.NoError(t, err)
+	assert.Equal(t, 5, count)
+}
+
+func TestEntryCompact(t *testing.T, s store.Store) {
+	create := func(j string, uids ...string) {
+		for _, uid := range uids {
+			require.NoError(t, s.CreateEntry(j, &api.Entry{UID: uid}))
+		}
+	}
+	uids := func(j string) []string {
+		es, err := s.GetEntries(j)
+		require.NoError(t, err)
+
+		var uids []string
+		for _, e := range es {
+			uids = append(uids, e.UID)
+		}
+		return uids
+	}
+	create("a", "e1", "e2", "e3", "e4", "e5")
+	create("b", "e1", "e2")
+
+	// every entry was stored since an hour ago
+	n, err := s.CompactEntries("a", store.Retention{Since: time.Now().Add(-time.Hour)})
+	require.NoError(t, err)
+	assert.Equal(t, 0, n)
+
+	n, err = s.CompactEntries("a", store.Retention{Count: 2, Since: time.Now().Add(time.Hour)})
+	require.NoError(t, err)
+	assert.Equal(t, 3, n)
+	assert.Equal(t, []string{"e4", "e5"}, uids("a"))
+
+	// the last entry is always kept
+	n, err = s.CompactEntries("a", store.Retention{})
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+	assert.Equal(t, []string{"e5"}, uids("a"))
+
+	last, err := s.LastEntry("a")
+	require.NoError(t, err)
+	assert.Equal(t, "e5", last.UID)
+
+	_, err = s.GetEntry("a", "e1")
+	assert.Equal(t, store.ErrRecordNotFound, err)
+
+	// syncing goes on from the last entry
+	create("a", "e6")
+	es, err := s.EntriesAfter("a", "e5", 0)
+	require.NoError(t, err)
+	require.Len(t, es, 1)
+	assert.Equal(t, "e6", es[0].UID)
+
+	t.Run("other journals", func(t *testing.T) {
+		assert.Equal(t, []string{"e1", "e2"}, uids("b"))
+	})
+
+	t.Run("empty", func(t *testing.T) {
+		n, err := s.CompactEntries("xxx", store.Retention{})
+		require.NoError(t, err)
+		assert.Equal(t, 0, n)
+	})
+}
+
+func TestJournalCreate(t *testing.T, s store.Store) {
+	j := &api.Journal{
+		Version: 2, UID: "abcd", Content: "data", Owner: "me@example.com", Key: "key", ReadOnly: true,
+	}
+	require.NoError(t, s.CreateJournal(j))
+
+	found, err := s.GetJournal(j.UID)
+	require.NoError(t, err)
+	assert.Equal(t, j, found)
+}
+
+func TestJournalNotFound(t *testing.T, s store.Store) {
+	notFound, err := s.GetJournal("abcd")
+	assert.Equal(t, store.ErrRecordNotFound, err)
+	assert.Nil(t, notFound)
+
+	err = s.UpdateJournal(&api.Journal{UID: "abcd"})
+	assert.Equal(t, store.ErrRecordNotFound, err)
+
+	err = s.DeleteJournal("abcd")
+	assert.Equal(t, store.ErrRecordNotFound, err)
+}
+
+func TestJournalUpdate(t *testing.T, s store.Store) {
+	j := &api.Journal{Version: 1, UID: "abcd", Content: "data", ReadOnly: true}
+	require.NoError(t, s.CreateJournal(j))
+
+	updated := &api.Journal{Version: 2, UID: "abcd", Content: "new data", ReadOnly: false}
+	require.NoError(t, s.UpdateJournal(updated))
+
+	found, err := s.GetJournal(j.UID)
+	require.NoError(t, err)
+	assert.Equal(t, updated, found)
+}
+
+func TestJournalGetJournals(t *testing.T, s store.Store) {
+	journals, err := s.GetJournals()
+	require.NoError(t, err)
+	assert.Len(t, journals, 0)
+
+	for i := 0; i < 10; i++ {
+		j := &api.Journal{UID: fmt.Sprintf("uid%d", i)}
+		require.NoError(t, s.CreateJournal(j))
+	}
+
+	journals, err = s.GetJournals()
+	require.NoError(t, err)
+	require.Len(t, journals, 10)
+	for i, j := range journals {
+		assert.Equal(t, fmt.Sprintf("uid%d", i), j.UID)
+	}
+}
+
+func TestJournalDelete(t *testing.T, s store.Store) {
+	require.NoError(t, s.CreateJournal(&api.Journal{UID: "a"}))
+	require.NoError(t, s.CreateJournal(&api.Journal{UID: "b"}))
+
+	require.NoError(t, s.DeleteJournal("a"))
+
+	_, err := s.GetJournal("a")
+	assert.Equal(t, store.ErrRecordNotFound, err)
+
+	journals, err := s.GetJournals()
+	require.NoError(t, err)
+	require.Len(t, journals, 1)
+	assert.Equal(t, "b", journals[0].UID)
+}
+
+func TestJournalPurge(t *testing.T, s store.Store) {
+	for _, uid := range []string{"a", "b"} {
+		require.NoError(t, s.CreateJournal(&api.Journal{UID: uid}))
+		require.NoError(t, s.CreateEntry(uid, &api.Entry{UID: uid + "-e1"}))
+		require.NoError(t, s.PutItem(uid, &store.Item{UID: "item", Entry: &api.Entry{UID: uid + "-e1"}}))
+		require.NoError(t, s.PutSyncState(&store.SyncState{JournalUID: uid, LastAttempt: time.Now()}))
+		enqueue(t, s, uid, uid+"-e2")
+		addConflicts(t, s, uid, "item")
+	}
+
+	require.NoError(t, s.PurgeJournal("a"))
+
+	_, err := s.GetJournal("a")
+	assert.Equal(t, store.ErrRecordNotFound, err)
+
+	count, err := s.CountEntries("a")
+	require.NoError(t, err)
+	assert.Equal(t, 0, count)
+
+	items, err := s.Items("a")
+	require.NoError(t, err)
+	assert.Len(t, items, 0)
+
+	_, err = s.SyncState("a")
+	assert.Equal(t, store.ErrRecordNotFound, err)
+
+	pending, err := s.PendingChanges("a")
+	require.NoError(t, err)
+	assert.Len(t, pending, 0)
+
+	conflicts, err := s.Conflicts("a")
+	require.NoError(t, err)
+	assert.Len(t, conflicts, 0)
+
+	t.Run("keeps other journals", func(t *testing.T) {
+		_, err := s.GetJournal("b")
+		assert.NoError(t, err)
+
+		count, err := s.CountEntries("b")
+		require.NoError(t, err)
+		assert.Equal(t, 1, count)
+
+		_, err = s.Item("b", "item")
+		assert.NoError(t, err)
+
+		_, err = s.SyncState("b")
+		assert.NoError(t, err)
+
+		pending, err := s.PendingChanges("b")
+		require.NoError(t, err)
+		assert.Len(t, pending, 1)
+
+		conflicts, err := s.Conflicts("b")
+		require.NoError(t, err)
+		assert.Len(t, conflicts, 1)
+	})
+
+	t.Run("missing", func(t *testing.T) {
+		assert.NoError(t, s.PurgeJournal("a"))
+		assert.NoError(t, s.PurgeJournal("xxx"))
+	})
+}
+
+func TestItemPut(t *testing.T, s store.Store) {
+	item := &store.Item{UID: "item", Entry: &api.Entry{UID: "e1", Content: "v1"}}
+	require.NoError(t, s.PutItem("parent", item))
+
+	found, err := s.Item("parent", item.UID)
+	require.NoError(t, err)
+	assert.Equal(t, item, found)
+
+	t.Run("replaces", func(t *testing.T) {
+		item := &store.Item{UID: "item", Entry: &api.Entry{UID: "e2", Content: "v2"}}
+		require.NoError(t, s.PutItem("parent", item))
+
+		found, err := s.Item("parent", item.UID)
+		require.NoError(t, err)
+		assert.Equal(t, item, found)
+
+		items, err := s.Items("parent")
+		require.NoError(t, err)
+		assert.Len(t, items, 1)
+	})
+}
+
+func TestItemNotFound(t *testing.T, s store.Store) {
+	require.NoError(t, s.PutItem("parent", &store.Item{UID: "item", Entry: &api.Entry{UID: "e1"}}))
+
+	notFound, err := s.Item("other", "item")
+	assert.Equal(t, store.ErrRecordNotFound, err)
+	assert.Nil(t, notFound)
+
+	err = s.DeleteItem("other", "item")
+	assert.Equal(t, store.ErrRecordNotFound, err)
+}
+
+func TestItemDelete(t *testing.T, s store.Store) {
+	require.NoError(t, s.PutItem("parent", &store.Item{UID: "a", Entry: &api.Entry{UID: "e1"}}))
+	require.NoError(t, s.PutItem("parent", &store.Item{UID: "b", Entry: &api.Entry{UID: "e2"}}))
+
+	require.NoError(t, s.DeleteItem("parent", "a"))
+
+	_, err := s.Item("parent", "a")
+	assert.Equal(t, store.ErrRecordNotFound, err)
+
+	items, err := s.Items("parent")
+	require.NoError(t, err)
+	require.Len(t, items, 1)
+	assert.Equal(t, "b", items[0].UID)
+}
+
+func TestItemItems(t *testing.T, s store.Store) {
+	total := 20
+	for i := 0; i < total; i++ {
+		item := &store.Item{UID: fmt.Sprintf("uid%d", i), Entry: &api.Entry{UID: fmt.Sprintf("e%d", i)}}
+
+		require.NoError(t, s.PutItem("uid-a", item))
+		require.NoError(t, s.PutItem("uid-b", item))
+	}
+
+	items, err := s.Items("uid-a")
+	require.NoError(t, err)
+	require.Len(t, items, total)
+	for i, item := range items {
+		assert.Equal(t, fmt.Sprintf("uid%d", i), item.UID)
+	}
+
+	t.Run("empty items", func(t *testing.T) {
+		items, err := s.Items("uid-xxx")
+		require.NoError(t, err)
+		assert.Len(t, items, 0)
+	})
+}
+
+// enqueue adds a change to the outbox for every entry uid
+func enqueue(t *testing.T, s store.Store, j string, uids ...string) store.Changes {
+	var changes store.Changes
+	for _, uid := range uids {
+		c := &store.Change{
+			JournalUID: j,
+			Entry:      &api.Entry{UID: uid, Content: "content-" + uid},
+			CreatedAt:  time.Now(),
+		}
+		require.NoError(t, s.EnqueueChange(c))
+		changes = append(changes, c)
+	}
+	return changes
+}
+
+func TestOutboxEnqueue(t *testing.T, s store.Store) {
+	enqueue(t, s, "a", "e1", "e2")
+	enqueue(t, s, "b", "e3")
+	enqueue(t, s, "a", "e4")
+
+	pending, err := s.PendingChanges("a")
+	require.NoError(t, err)
+	require.Len(t, pending, 3)
+
+	var uids []string
+	for i, c := range pending {
+		if i > 0 {
+			assert.True(t, c.ID > pending[i-1].ID, "ids should increase")
+		}
+		assert.Equal(t, "a", c.JournalUID)
+		assert.Equal(t, "content-"+c.Entry.UID, c.Entry.Content)
+		assert.WithinDuration(t, time.Now(), c.CreatedAt, time.Minute)
+		assert.True(t, c.SentAt.IsZero())
+		uids = append(uids, c.Entry.UID)
+	}
+	assert.Equal(t, []string{"e1", "e2", "e4"}, uids)
+
+	t.Run("all journals", func(t *testing.T) {
+		pending, err := s.PendingChanges("")
+		require.NoError(t, err)
+		assert.Len(t, pending, 4)
+	})
+
+	t.Run("empty", func(t *testing.T) {
+		pending, err := s.PendingChanges("xxx")
+		require.NoError(t, err)
+		assert.Len(t, pending, 0)
+	})
+}
+
+func TestOutboxMarkSent(t *testing.T, s store.Store) {
+	changes := enqueue(t, s, "a", "e1", "e2")
+
+	require.NoError(t, s.MarkChangeSent(changes[0].ID))
+
+	pending, err := s.PendingChanges("a")
+	require.NoError(t, err)
+	require.Len(t, pending, 1)
+	assert.Equal(t, changes[1].ID, pending[0].ID)
+}
+
+func TestOutboxDiscard(t *testing.T, s store.Store) {
+	changes := enqueue(t, s, "a", "e1", "e2")
+
+	require.NoError(t, s.DiscardChange(changes[1].ID))
+
+	pending, err := s.PendingChanges("a")
+	require.NoError(t, err)
+	require.Len(t, pending, 1)
+	assert.Equal(t, changes[0].ID, pending[0].ID)
+}
+
+func TestOutboxNotFound(t *testing.T, s store.Store) {
+	changes := enqueue(t, s, "a", "e1")
+	id := changes[0].ID + 1
+
+	assert.Equal(t, store.ErrRecordNotFound, s.MarkChangeSent(id))
+	assert.Equal(t, store.ErrRecordNotFound, s.DiscardChange(id))
+}
+
+// addConflicts records a conflict for every item uid
+func addConflicts(t *testing.T, s store.Store, j string, uids ...string) store.Conflicts {
+	var conflicts store.Conflicts
+	for _, uid := range uids {
+		c := &store.Conflict{
+			JournalUID: j,
+			ItemUID:    uid,
+			Local:      &api.Entry{UID: "local-" + uid, Content: "local"},
+			Remote:     &api.Entry{UID: "remote-" + uid, Content: "remote"},
+			CreatedAt:  time.Now(),
+		}
+		require.NoError(t, s.AddConflict(c))
+		conflicts = append(conflicts, c)
+	}
+	return conflicts
+}
+
+func TestConflictAdd(t *testing.T, s store.Store) {
+	addConflicts(t, s, "a", "i1", "i2")
+	addConflicts(t, s, "b", "i3")
+
+	conflicts, err := s.Conflicts("a")
+	require.NoError(t, err)
+	require.Len(t, conflicts, 2)
+
+	for i, c := range conflicts {
+		if i > 0 {
+			assert.True(t, c.ID > conflicts[i-1].ID, "ids should increase")
+		}
+		assert.Equal(t, "a", c.JournalUID)
+		assert.Equal(t, &api.Entry{UID: "local-" + c.ItemUID, Content: "local"}, c.Local)
+		assert.Equal(t, &api.Entry{UID: "remote-" + c.ItemUID, Content: "remote"}, c.Remote)
+		assert.WithinDuration(t, time.Now(), c.CreatedAt, time.Minute)
+	}
+	assert.Equal(t, "i1", conflicts[0].ItemUID)
+	assert.Equal(t, "i2", conflicts[1].ItemUID)
+
+	t.Run("all journals", func(t *testing.T) {
+		conflicts, err := s.Conflicts("")
+		require.NoError(t, err)
+		assert.Len(t, conflicts, 3)
+	})
+
+	t.Run("empty", func(t *testing.T) {
+		conflicts, err := s.Conflicts("xxx")
+		require.NoError(t, err)
+		assert.Len(t, conflicts, 0)
+	})
+}
+
+func TestConflictDelete(t *testing.T, s store.Store) {
+	added := addConflicts(t, s, "a", "i1", "i2")
+
+	require.NoError(t, s.DeleteConflict(added[0].ID))
+
+	conflicts, err := s.Conflicts("a")
+	require.NoError(t, err)
+	require.Len(t, conflicts, 1)
+	assert.Equal(t, added[1].ID, conflicts[0].ID)
+
+	assert.Equal(t, store.ErrRecordNotFound, s.DeleteConflict(added[0].ID))
+}
+
+func TestSyncStatePut(t *testing.T, s store.Store) {
+	now := time.Now()
+	st := &store.SyncState{
+		JournalUID:      "a",
+		LastAttempt:     now,
+		LastEntryUID:    "e1",
+		Entries:         3,
+		Invalid:         1,
+		CompactedBefore: "e0",
+		Error:           "sync error",
+		Duration:        2 * time.Second,
+	}
+	require.NoError(t, s.PutSyncState(st))
+
+	found, err := s.SyncState("a")
+	require.NoError(t, err)
+	assert.Equal(t, "a", found.JournalUID)
+	assert.WithinDuration(t, now, found.LastAttempt, time.Millisecond)
+	assert.True(t, found.LastSuccess.IsZero())
+	assert.Equal(t, "e1", found.LastEntryUID)
+	assert.Equal(t, 3, found.Entries)
+	assert.Equal(t, 1, found.Invalid)
+	assert.Equal(t, "e0", found.CompactedBefore)
+	assert.Equal(t, "sync error", found.Error)
+	assert.Equal(t, 2*time.Second, found.Duration)
+
+	assert.True(t, found.Removed.IsZero())
+
+	t.Run("replaces", func(t *testing.T) {
+		st.LastSuccess = now
+		st.Error = ""
+		st.Removed = now
+		require.NoError(t, s.PutSyncState(st))
+
+		found, err := s.SyncState("a")
+		require.NoError(t, err)
+		assert.WithinDuration(t, now, found.LastSuccess, time.Millisecond)
+		assert.Equal(t, "", found.Error)
+		assert.WithinDuration(t, now, found.Removed, time.Millisecond)
+
+		states, err := s.SyncStates()
+		require.NoError(t, err)
+		assert.Len(t, states, 1)
+	})
+}
+
+func TestSyncStateNotFound(t *testing.T, s store.Store) {
+	require.NoError(t, s.PutSyncState(&store.SyncState{JournalUID: "a", LastAttempt: time.Now()}))
+
+	notFound, err := s.SyncState("b")
+	assert.Equal(t, store.ErrRecordNotFound, err)
+	assert.Nil(t, notFound)
+}
+
+func TestSyncStates(t *testing.T, s store.Store) {
+	for _, uid := range []string{"c", "a", "b"} {
+		require.NoError(t, s.PutSyncState(&store.SyncState{JournalUID: uid, LastAttempt: time.Now()}))
+	}
+
+	states, err := s.SyncStates()
+	require.NoError(t, err)
+	require.Len(t, states, 3)
+
+	var uids []string
+	for _, st := range states {
+		uids = append(uids, st.JournalUID)
+	}
+	assert.Equal(t, []string{"a", "b", "c"}, uids)
+}
+
+// writeAccount writes some of every kind of data on journal "parent"
+func writeAccount(t *testing.T, s store.Store) {
+	require.NoError(t, writeAll(s))
+	require.NoError(t, s.PutSyncState(&store.SyncState{JournalUID: "parent", LastAttempt: time.Now()}))
+	enqueue(t, s, "parent", "e2")
+}
+
+func TestAccountIsolation(t *testing.T, s store.Store) {
+	a, b := s.ForAccount("a"), s.ForAccount("b")
+	writeAccount(t, a)
+
+	for _, other := range []store.Store{s, b} {
+		_, err := other.GetJournal("parent")
+		assert.Equal(t, store.ErrRecordNotFound, err)
+
+		_, err = other.GetEntry("parent", "e1")
+		assert.Equal(t, store.ErrRecordNotFound, err)
+
+		_, err = other.Item("parent", "item")
+		assert.Equal(t, store.ErrRecordNotFound, err)
+
+		_, err = other.SyncState("parent")
+		assert.Equal(t, store.ErrRecordNotFound, err)
+
+		pending, err := other.PendingChanges("")
+		require.NoError(t, err)
+		assert.Len(t, pending, 0)
+	}
+
+	t.Run("same uids", func(t *testing.T) {
+		writeAccount(t, b)
+		require.NoError(t, b.PurgeJournal("parent"))
+
+		_, err := a.GetJournal("parent")
+		assert.NoError(t, err)
+
+		count, err := a.CountEntries("parent")
+		require.NoError(t, err)
+		assert.Equal(t, 1, count)
+
+		_, err = a.Item("parent", "item")
+		assert.NoError(t, err)
+
+		pending, err := a.PendingChanges("parent")
+		require.NoError(t, err)
+		assert.Len(t, pending, 1)
+	})
+}
+
+func TestAccounts(t *testing.T, s store.Store) {
+	accounts, err := s.Accounts()
+	require.NoError(t, err)
+	assert.Len(t, accounts, 0)
+
+	for _, account := range []string{"b", "", "a"} {
+		require.NoError(t, s.ForAccount(account).CreateJournal(&api.Journal{UID: "j"}))
+	}
+	// accounts without journals are not listed
+	require.NoError(t, s.ForAccount("c").CreateEntry("j", &api.Entry{UID: "e1"}))
+
+	accounts, err = s.ForAccount("a").Accounts()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"", "a", "b"}, accounts)
+}
+
+func TestAccountTx(t *testing.T, s store.Store) {
+	txErr := errors.New("tx error")
+	err := s.ForAccount("a").WithTx(func(tx store.Store) error {
+		if err := writeAll(tx); err != nil {
+			return err
+		}
+		if err := writeAll(tx.ForAccount("b")); err != nil {
+			return err
+		}
+		return txErr
+	})
+	assert.Equal(t, txErr, err)
+
+	accounts, err := s.Accounts()
+	require.NoError(t, err)
+	assert.Len(t, accounts, 0)
+
+	err = s.ForAccount("a").WithTx(func(tx store.Store) error {
+		if err := writeAll(tx); err != nil {
+			return err
+		}
+		return writeAll(tx.ForAccount("b"))
+	})
+	require.NoError(t, err)
+
+	for _, account := range []string{"a", "b"} {
+		_, err := s.ForAccount(account).GetEntry("parent", "e1")
+		assert.NoError(t, err)
+	}
+}
+
+// writeAll creates a journal with an entry and an item
+func writeAll(s store.Store) error {
+	if err := s.CreateJournal(&api.Journal{UID: "parent"}); err != nil {
+		return err
+	}
+
+	if err := s.CreateEntry("parent", &api.Entry{UID: "e1"}); err != nil {
+		return err
+	}
+
+	return s.PutItem("parent", &store.Item{UID: "item", Entry: &api.Entry{UID: "e1"}})
+}
+
+func TestTxCommit(t *testing.T, s store.Store) {
+	require.NoError(t, s.WithTx(writeAll))
+
+	_, err := s.GetJournal("parent")
+	assert.NoError(t, err)
+
+	_, err = s.GetEntry("parent", "e1")
+	assert.NoError(t, err)
+
+	_, err = s.Item("parent", "item")
+	assert.NoError(t, err)
+}
+
+func TestTxRollback(t *testing.T, s store.Store) {
+	require.NoError(t, s.CreateEntry("parent", &api.Entry{UID: "e0"}))
+
+	txErr := errors.New("tx error")
+	err := s.WithTx(func(tx store.Store) error {
+		if err := writeAll(tx); err != nil {
+			return err
+		}
+
+		// changes are visible within the transaction
+		last, err := tx.LastEntry("parent")
+		require.NoError(t, err)
+		assert.Equal(t, "e1", last.UID)
+
+		return txErr
+	})
+	assert.Equal(t, txErr, err)
+
+	_, err = s.GetJournal("parent")
+	assert.Equal(t, store.ErrRecordNotFound, err)
+
+	last, err := s.LastEntry("parent")
+	require.NoError(t, err)
+	assert.Equal(t, "e0", last.UID)
+
+	_, err = s.Item("parent", "item")
+	assert.Equal(t, store.ErrRecordNotFound, err)
+}
+
+func TestTxNested(t *testing.T, s store.Store) {
+	txErr := errors.New("tx error")
+	err := s.WithTx(func(tx store.Store) error {
+		if err := tx.WithTx(writeAll); err != nil {
+			return err
+		}
+		return txErr
+	})
+	assert.Equal(t, txErr, err)
+
+	_, err = s.GetEntry("parent", "e1")
+	assert.Equal(t, store.ErrRecordNotFound, err)
+}